@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azkeys"
+
+	"azkeyvault-perm-tester/pkg/permtest"
+)
+
+// algorithmGroup is a set of algorithms usable with one kind of key, as
+// printed by -list-algorithms.
+type algorithmGroup struct {
+	keys       string
+	algorithms []string
+}
+
+// algorithmList is one section of -list-algorithms: the flag the algorithms
+// are passed to, and the algorithms grouped by key type.
+type algorithmList struct {
+	title  string
+	flag   string
+	groups []algorithmGroup
+}
+
+var (
+	rsaEncryptionAlgorithms = []string{"RSA1_5", "RSA-OAEP", "RSA-OAEP-256"}
+	aesEncryptionAlgorithms = []string{"A128GCM", "A192GCM", "A256GCM", "A128CBC", "A192CBC", "A256CBC", "A128CBCPAD", "A192CBCPAD", "A256CBCPAD"}
+	aesWrapAlgorithms       = []string{"A128KW", "A192KW", "A256KW"}
+)
+
+// supportedAlgorithms returns every algorithm the tool can test, in the
+// sections -list-algorithms prints.
+func supportedAlgorithms() []algorithmList {
+	signature := algorithmList{title: "Signature algorithms", flag: "-algorithm"}
+	signature.groups = append(signature.groups, algorithmGroup{keys: "RSA, RSA-HSM", algorithms: algorithmNames(permtest.SignatureAlgorithms(azkeys.KeyTypeRSA, ""))})
+	for _, curve := range []azkeys.CurveName{azkeys.CurveNameP256, azkeys.CurveNameP256K, azkeys.CurveNameP384, azkeys.CurveNameP521} {
+		signature.groups = append(signature.groups, algorithmGroup{
+			keys:       "EC, EC-HSM on " + string(curve),
+			algorithms: algorithmNames(permtest.SignatureAlgorithms(azkeys.KeyTypeEC, curve)),
+		})
+	}
+	signature.groups = append(signature.groups, algorithmGroup{keys: "OKP, OKP-HSM on " + string(permtest.CurveNameEd25519), algorithms: []string{string(permtest.SignatureAlgorithmEdDSA)}})
+
+	return []algorithmList{
+		signature,
+		{title: "Encryption algorithms", flag: "-encryption-algorithm", groups: []algorithmGroup{
+			{keys: "RSA, RSA-HSM", algorithms: rsaEncryptionAlgorithms},
+			{keys: "oct, oct-HSM", algorithms: aesEncryptionAlgorithms},
+		}},
+		{title: "Key wrap algorithms", flag: "-wrap-algorithm", groups: []algorithmGroup{
+			{keys: "RSA, RSA-HSM", algorithms: rsaEncryptionAlgorithms},
+			{keys: "oct, oct-HSM", algorithms: aesWrapAlgorithms},
+		}},
+	}
+}
+
+func algorithmNames(algorithms []azkeys.SignatureAlgorithm) []string {
+	names := make([]string, len(algorithms))
+	for i, alg := range algorithms {
+		names[i] = string(alg)
+	}
+	return names
+}
+
+// printAlgorithms writes the supported algorithms grouped by key type, for
+// -list-algorithms.
+func printAlgorithms(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for i, list := range supportedAlgorithms() {
+		if i > 0 {
+			fmt.Fprintln(tw)
+		}
+		fmt.Fprintf(tw, "%s (%s):\n", list.title, list.flag)
+		for _, g := range list.groups {
+			fmt.Fprintf(tw, "  %s\t%s\n", g.keys, strings.Join(g.algorithms, ", "))
+		}
+	}
+	return tw.Flush()
+}
+
+// checkSignatureAlgorithm rejects an -algorithm value that is not a known
+// signature algorithm, suggesting the closest one, e.g. RS256 for RSA256.
+func checkSignatureAlgorithm(value string) error {
+	names := algorithmNames(signatureAlgorithms)
+	if slices.Contains(names, value) {
+		return nil
+	}
+	if match := closestMatch(value, names); match != "" {
+		return fmt.Errorf("unknown -algorithm %q; did you mean %s? (see -list-algorithms)", value, match)
+	}
+	return fmt.Errorf("unknown -algorithm %q; valid values are %s (see -list-algorithms)", value, strings.Join(names, ", "))
+}
+
+// closestMatch returns the candidate nearest to value by edit distance,
+// ignoring case, or "" when even the nearest differs in more than half of
+// its characters and so is unlikely to be what was meant.
+func closestMatch(value string, candidates []string) string {
+	best, bestDistance := "", -1
+	for _, c := range candidates {
+		d := levenshtein(strings.ToUpper(value), strings.ToUpper(c))
+		if bestDistance < 0 || d < bestDistance {
+			best, bestDistance = c, d
+		}
+	}
+	if bestDistance < 0 || bestDistance > len(best)/2 {
+		return ""
+	}
+	return best
+}
+
+// levenshtein returns the number of single-character insertions, deletions,
+// and substitutions that turn a into b.
+func levenshtein(a, b string) int {
+	s, t := []rune(a), []rune(b)
+	prev := make([]int, len(t)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := range s {
+		cur := make([]int, len(t)+1)
+		cur[0] = i + 1
+		for j := range t {
+			cost := 1
+			if s[i] == t[j] {
+				cost = 0
+			}
+			cur[j+1] = min(prev[j+1]+1, cur[j]+1, prev[j]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(t)]
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azkeys"
+
+	"azkeyvault-perm-tester/pkg/permtest"
+)
+
+// dummySignatureSize returns the length of a signature made with algorithm:
+// the r||s encoding for ECDSA and EdDSA. It returns zero for the RSA
+// algorithms, whose signatures are as long as the key's modulus.
+func dummySignatureSize(algorithm azkeys.SignatureAlgorithm) int {
+	switch algorithm {
+	case azkeys.SignatureAlgorithmES256, azkeys.SignatureAlgorithmES256K, permtest.SignatureAlgorithmEdDSA:
+		return 64
+	case azkeys.SignatureAlgorithmES384:
+		return 96
+	case azkeys.SignatureAlgorithmES512:
+		return 132
+	}
+	return 0
+}
+
+// isRSAKeyType, isECKeyType, and isOKPKeyType accept both software and HSM
+// key types.
+func isRSAKeyType(keyType string) bool {
+	return keyType == string(azkeys.KeyTypeRSA) || keyType == string(azkeys.KeyTypeRSAHSM)
+}
+
+func isECKeyType(keyType string) bool {
+	return keyType == string(azkeys.KeyTypeEC) || keyType == string(azkeys.KeyTypeECHSM)
+}
+
+func isOKPKeyType(keyType string) bool {
+	return keyType == string(permtest.KeyTypeOKP) || keyType == string(permtest.KeyTypeOKPHSM)
+}
+
+// keyDescription renders a key type and curve for messages, e.g.
+// "EC-HSM key on curve P-256".
+func keyDescription(keyType string, curve string) string {
+	if curve != "" {
+		return fmt.Sprintf("%s key on curve %s", keyType, curve)
+	}
+	return fmt.Sprintf("%s key", keyType)
+}
+
+// algorithmWarning explains why algorithm cannot be used with the given key,
+// or returns "" when it is compatible.
+func algorithmWarning(algorithm azkeys.SignatureAlgorithm, keyType string, curve string) string {
+	compatible := permtest.SignatureAlgorithms(azkeys.KeyType(keyType), azkeys.CurveName(curve))
+	if len(compatible) == 0 {
+		return fmt.Sprintf("Signature algorithm %s cannot be checked: %s does not support signing", algorithm, keyDescription(keyType, curve))
+	}
+	names := make([]string, len(compatible))
+	for i, alg := range compatible {
+		if alg == algorithm {
+			return ""
+		}
+		names[i] = string(alg)
+	}
+	return fmt.Sprintf("Signature algorithm %s is not compatible with this %s; sign and verify will fail (use -auto-algorithm or one of: %s)",
+		algorithm, keyDescription(keyType, curve), strings.Join(names, ", "))
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+)
+
+// Supported values for the -auth-mode flag.
+const (
+	authModeDefault         = "default"
+	authModeSPSecret        = "sp-secret"
+	authModeSPCert          = "sp-cert"
+	authModeManagedIdentity = "managed-identity"
+	authModeCLI             = "cli"
+	authModeInteractive     = "interactive"
+)
+
+// credentialTypes names the azidentity credential each auth mode uses.
+var credentialTypes = map[string]string{
+	authModeDefault:         "DefaultAzureCredential",
+	authModeSPSecret:        "ClientSecretCredential",
+	authModeSPCert:          "ClientCertificateCredential",
+	authModeManagedIdentity: "ManagedIdentityCredential",
+	authModeCLI:             "AzureCLICredential",
+	authModeInteractive:     "InteractiveBrowserCredential",
+}
+
+// authConfig holds the settings used to build a credential.
+type authConfig struct {
+	mode         string
+	tenantID     string
+	clientID     string
+	clientSecret string
+	certPath     string
+	certPassword string
+	redirectURL  string
+	cloud        cloud.Configuration
+	appID        string
+
+	// transport, when not nil, sends token requests through a proxy or
+	// with additional trusted CAs.
+	transport policy.Transporter
+}
+
+// newCredential builds the credential selected by cfg.mode. The default
+// credential chain is only used for authModeDefault.
+func newCredential(cfg authConfig) (azcore.TokenCredential, error) {
+	clientOptions := azcore.ClientOptions{
+		Cloud:     cfg.cloud,
+		Transport: cfg.transport,
+		Telemetry: policy.TelemetryOptions{ApplicationID: cfg.appID},
+	}
+
+	switch cfg.mode {
+	case authModeDefault:
+		return azidentity.NewDefaultAzureCredential(&azidentity.DefaultAzureCredentialOptions{
+			ClientOptions: clientOptions,
+			TenantID:      cfg.tenantID,
+		})
+
+	case authModeSPSecret:
+		secret := cfg.clientSecret
+		if secret == "" {
+			// Prefer the environment so the secret doesn't end up in shell history
+			secret = os.Getenv("AZURE_CLIENT_SECRET")
+		}
+		if cfg.tenantID == "" || cfg.clientID == "" || secret == "" {
+			return nil, fmt.Errorf("auth mode %s requires -tenant-id, -client-id, and -client-secret (or AZURE_CLIENT_SECRET)", cfg.mode)
+		}
+		return azidentity.NewClientSecretCredential(cfg.tenantID, cfg.clientID, secret, &azidentity.ClientSecretCredentialOptions{
+			ClientOptions: clientOptions,
+		})
+
+	case authModeSPCert:
+		if cfg.tenantID == "" || cfg.clientID == "" || cfg.certPath == "" {
+			return nil, fmt.Errorf("auth mode %s requires -tenant-id, -client-id, and -cert-path", cfg.mode)
+		}
+		data, err := os.ReadFile(cfg.certPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read certificate: %w", err)
+		}
+		// ParseCertificates handles both PEM and PKCS#12 (PFX) encodings
+		certs, key, err := azidentity.ParseCertificates(data, []byte(cfg.certPassword))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse certificate %s: %w", cfg.certPath, err)
+		}
+		return azidentity.NewClientCertificateCredential(cfg.tenantID, cfg.clientID, certs, key, &azidentity.ClientCertificateCredentialOptions{
+			ClientOptions: clientOptions,
+		})
+
+	case authModeManagedIdentity:
+		options := &azidentity.ManagedIdentityCredentialOptions{ClientOptions: clientOptions}
+		if cfg.clientID != "" {
+			// A client ID selects a user-assigned identity
+			options.ID = azidentity.ClientID(cfg.clientID)
+		}
+		return azidentity.NewManagedIdentityCredential(options)
+
+	case authModeCLI:
+		return azidentity.NewAzureCLICredential(&azidentity.AzureCLICredentialOptions{
+			TenantID: cfg.tenantID,
+		})
+
+	case authModeInteractive:
+		// Without a client ID the SDK uses a Microsoft-owned application
+		// that redirects to localhost
+		return azidentity.NewInteractiveBrowserCredential(&azidentity.InteractiveBrowserCredentialOptions{
+			ClientOptions: clientOptions,
+			TenantID:      cfg.tenantID,
+			ClientID:      cfg.clientID,
+			RedirectURL:   cfg.redirectURL,
+		})
+
+	default:
+		return nil, fmt.Errorf("unknown auth mode %q (expected %s, %s, %s, %s, %s, or %s)", cfg.mode,
+			authModeDefault, authModeSPSecret, authModeSPCert, authModeManagedIdentity, authModeCLI, authModeInteractive)
+	}
+}
+
+// scopedCredential requests every token for scope, for -scope, in place of
+// the scope the Key Vault clients derive from the vault's authentication
+// challenge or that tokenScope derives from the cloud.
+type scopedCredential struct {
+	azcore.TokenCredential
+	scope string
+}
+
+func (c scopedCredential) GetToken(ctx context.Context, opts policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	opts.Scopes = []string{c.scope}
+	return c.TokenCredential.GetToken(ctx, opts)
+}
+
+// parseScope validates a -scope value. A bare resource such as
+// https://vault.example.com gets the /.default suffix that requests the
+// resource's configured permissions; a value with a path is used as given.
+func parseScope(value string) (string, error) {
+	u, err := url.Parse(value)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return "", fmt.Errorf("invalid -scope %q: expected an absolute URI such as https://vault.azure.net/.default", value)
+	}
+	if u.Path == "" || u.Path == "/" {
+		return strings.TrimSuffix(value, "/") + "/.default", nil
+	}
+	return value, nil
+}
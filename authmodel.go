@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Authorization models inferred from a 403 response. A vault authorizes data
+// plane calls either with Azure RBAC role assignments or with legacy access
+// policies, and the fix for a denial differs between the two. A 403 can also
+// come from the vault firewall, in which case no permission change helps.
+const (
+	authModelRBAC         = "rbac"
+	authModelAccessPolicy = "accessPolicy"
+	authModelFirewall     = "firewall"
+)
+
+// inferAuthModel infers which authorization model denied a request from the
+// inner error code of a 403 body, falling back to the wording of its message.
+// It returns "" when neither is recognized.
+func inferAuthModel(innerCode, message string) string {
+	switch innerCode {
+	case "ForbiddenByRbac":
+		return authModelRBAC
+	case "ForbiddenByPolicy", "AccessDenied":
+		return authModelAccessPolicy
+	case "ForbiddenByFirewall", "ForbiddenByConnection":
+		return authModelFirewall
+	}
+
+	msg := strings.ToLower(message)
+	switch {
+	case strings.Contains(msg, "client address is not authorized"):
+		return authModelFirewall
+	case strings.Contains(msg, "caller is not authorized to perform action"), strings.Contains(msg, "assignment:"):
+		return authModelRBAC
+	case strings.Contains(msg, "permission on key vault"), strings.Contains(msg, "access policy"):
+		return authModelAccessPolicy
+	}
+	return ""
+}
+
+// operationPermission is what grants an operation under each authorization
+// model: the least-privileged built-in RBAC role, and the access policy
+// permission with the plane it belongs to (key, secret, or certificate).
+type operationPermission struct {
+	role       string
+	plane      string
+	permission string
+}
+
+var operationPermissions = map[string]operationPermission{
+	opSign:      {"Key Vault Crypto User", "key", "sign"},
+	opVerify:    {"Key Vault Crypto User", "key", "verify"},
+	opGet:       {"Key Vault Crypto User", "key", "get"},
+	opEncrypt:   {"Key Vault Crypto User", "key", "encrypt"},
+	opDecrypt:   {"Key Vault Crypto User", "key", "decrypt"},
+	opWrapKey:   {"Key Vault Crypto User", "key", "wrapKey"},
+	opUnwrapKey: {"Key Vault Crypto User", "key", "unwrapKey"},
+	opList:      {"Key Vault Reader", "key", "list"},
+	opCreate:    {"Key Vault Crypto Officer", "key", "create"},
+	opDelete:    {"Key Vault Crypto Officer", "key", "delete"},
+	opPurge:     {"Key Vault Crypto Officer", "key", "purge"},
+	opImport:    {"Key Vault Crypto Officer", "key", "import"},
+	opRotate:    {"Key Vault Crypto Officer", "key", "rotate"},
+
+	opGetDeleted: {"Key Vault Crypto Officer", "key", "get"},
+	opRecover:    {"Key Vault Crypto Officer", "key", "recover"},
+	opUpdate:     {"Key Vault Crypto Officer", "key", "update"},
+
+	opNegativeVerify:    {"Key Vault Crypto User", "key", "verify"},
+	opRotationPolicyGet: {"Key Vault Crypto Officer", "key", "getrotationpolicy"},
+	opRotationPolicySet: {"Key Vault Crypto Officer", "key", "setrotationpolicy"},
+	opBackup:            {"Key Vault Crypto Officer", "key", "backup"},
+	opRestore:           {"Key Vault Crypto Officer", "key", "restore"},
+	opSecretGet:         {"Key Vault Secrets User", "secret", "get"},
+	opSecretSet:         {"Key Vault Secrets Officer", "secret", "set"},
+	opCertGet:           {"Key Vault Certificate User", "certificate", "get"},
+	opRandomBytes:       {"Key Vault Crypto User", "key", "rng"},
+}
+
+// remediation returns a hint for fixing a denial of op under model, or ""
+// when there is nothing specific to suggest.
+func remediation(op, model string) string {
+	if model == authModelFirewall {
+		return "The vault firewall rejected the request; allow this client's IP address or virtual network in the vault's networking settings"
+	}
+	perm, ok := operationPermissions[op]
+	if !ok {
+		return ""
+	}
+	switch model {
+	case authModelRBAC:
+		return fmt.Sprintf("Vault uses Azure RBAC; assign the '%s' role (or one that includes it) to this identity", perm.role)
+	case authModelAccessPolicy:
+		return fmt.Sprintf("Vault uses access policies; add the %s permission to this identity's access policy (az keyvault set-policy --%s-permissions %s)", perm.permission, perm.plane, perm.permission)
+	}
+	return ""
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azkeys"
+)
+
+// runBackupTests backs up the key and, with -test-restore, restores the
+// backup, passing each result to record as it completes. It stops early and
+// returns false as soon as record returns false.
+func runBackupTests(ctx context.Context, client keyVaultClient, keyName string, cfg testConfig, record func(testResult) bool) bool {
+	var blob []byte
+
+	if cfg.backup {
+		res := testResult{Operation: opBackup}
+		err := cfg.timed(ctx, &res, func(ctx context.Context) (err error) {
+			blob, err = doTestBackupKey(ctx, client, keyName)
+			return err
+		})
+		if err != nil {
+			res.fail(err)
+			blob = dryRunPlaceholder(err, 1)
+		} else {
+			res.Success = true
+			size := len(blob)
+			res.BackupSize = &size
+		}
+		if !record(res) {
+			return false
+		}
+	}
+
+	if cfg.restore {
+		res := testResult{Operation: opRestore}
+		if blob == nil {
+			res.skip("No backup available from backup test, skipping restore test")
+		} else {
+			var conflict bool
+			err := cfg.timed(ctx, &res, func(ctx context.Context) (err error) {
+				conflict, err = doTestRestoreKey(ctx, client, blob)
+				return err
+			})
+			if err != nil {
+				res.fail(err)
+			} else {
+				res.Success = true
+				if conflict {
+					res.Notes = append(res.Notes, "Restore was authorized but the key already exists (409 Conflict), so nothing was restored")
+				}
+			}
+		}
+		if !record(res) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func doTestBackupKey(ctx context.Context, client keyVaultClient, keyName string) ([]byte, error) {
+	resp, err := client.BackupKey(ctx, keyName, nil)
+	if err != nil {
+		return nil, fmt.Errorf("backup key operation failed: %w", err)
+	}
+	return resp.Value, nil
+}
+
+// doTestRestoreKey restores a key backup. Restoring over a key that still
+// exists is rejected with 409 Conflict, but only after the caller has been
+// authorized, so a conflict is reported as a granted permission.
+func doTestRestoreKey(ctx context.Context, client keyVaultClient, blob []byte) (bool, error) {
+	_, err := client.RestoreKey(ctx, azkeys.RestoreKeyParameters{KeyBackup: blob}, nil)
+	if err != nil {
+		var respErr *azcore.ResponseError
+		if errors.As(err, &respErr) && respErr.StatusCode == http.StatusConflict {
+			return true, nil
+		}
+		return false, fmt.Errorf("restore key operation failed: %w", err)
+	}
+	return false, nil
+}
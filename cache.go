@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+)
+
+// passCache remembers when each per-key test last passed, for -skip-recent.
+// Entries are keyed by identity, vault, key, and operation, so that a pass
+// by one identity is never taken as another's. It is read once before the
+// run, updated as results are recorded, and saved when the run ends: every
+// pass is stamped with the time it ran, and every failure removes its entry,
+// so a test skipped as a cached pass is retested once its window ends.
+type passCache struct {
+	path     string
+	identity string
+
+	// mu guards passes while keys are tested concurrently
+	mu     sync.Mutex
+	passes map[passKey]time.Time
+}
+
+type passKey struct {
+	identity, vault, key, operation string
+}
+
+// passCacheFile is the on-disk form of the cache.
+type passCacheFile struct {
+	Passes []passCacheEntry `json:"passes"`
+}
+
+type passCacheEntry struct {
+	Identity  string    `json:"identity"`
+	Vault     string    `json:"vault"`
+	Key       string    `json:"key"`
+	Operation string    `json:"operation"`
+	Passed    time.Time `json:"passed"`
+}
+
+// defaultPassCachePath is the cache file in the user's cache directory, e.g.
+// ~/.cache/azkeyvault-perm-tester/passes.json on Linux.
+func defaultPassCachePath() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "azkeyvault-perm-tester", "passes.json"), nil
+}
+
+// loadPassCache reads the cache at path for identity. A missing file is an
+// empty cache.
+func loadPassCache(path, identity string) (*passCache, error) {
+	c := &passCache{path: path, identity: identity, passes: make(map[passKey]time.Time)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return c, fmt.Errorf("failed to read cache file: %w", err)
+	}
+	var file passCacheFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return c, fmt.Errorf("failed to parse cache file %s: %w", path, err)
+	}
+	for _, e := range file.Passes {
+		c.passes[passKey{e.Identity, e.Vault, e.Key, e.Operation}] = e.Passed
+	}
+	return c, nil
+}
+
+// recentPasses returns when each operation on key last passed for the
+// cache's identity, for the operations that passed within window.
+func (c *passCache) recentPasses(vault, key string, window time.Duration) map[string]time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	recent := make(map[string]time.Time)
+	cutoff := time.Now().Add(-window)
+	for k, passed := range c.passes {
+		if k.identity == c.identity && k.vault == vault && k.key == key && passed.After(cutoff) {
+			recent[k.operation] = passed
+		}
+	}
+	return recent
+}
+
+// observe records the outcome of a per-key result. Skipped results leave the
+// cache alone, and cached passes keep the time of the pass they stand for.
+func (c *passCache) observe(r *runReport, res testResult) {
+	if r.KeyName == "" || res.Skipped || res.CachedPass != nil || res.Algorithm != "" {
+		return
+	}
+	k := passKey{c.identity, r.VaultURL, r.KeyName, res.Operation}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if res.Success {
+		c.passes[k] = time.Now().UTC()
+	} else {
+		delete(c.passes, k)
+	}
+}
+
+// save replaces the cache file, creating its directory as needed.
+func (c *passCache) save() error {
+	c.mu.Lock()
+	file := passCacheFile{Passes: []passCacheEntry{}}
+	for k, passed := range c.passes {
+		file.Passes = append(file.Passes, passCacheEntry{Identity: k.identity, Vault: k.vault, Key: k.key, Operation: k.operation, Passed: passed})
+	}
+	c.mu.Unlock()
+
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode cache file: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), 0o755); err != nil {
+		return fmt.Errorf("failed to create cache directory: %w", err)
+	}
+	tmp := c.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write cache file: %w", err)
+	}
+	if err := os.Rename(tmp, c.path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write cache file: %w", err)
+	}
+	return nil
+}
+
+// cacheIdentity names the identity whose passes the cache holds. A service
+// principal or a managed identity given a client ID is named by it, without
+// a token request; any other credential signs in whoever is logged in, so it
+// is named by the object ID in its token, from claims when -whoami or
+// -suggest-fix already read them.
+func cacheIdentity(ctx context.Context, auth authConfig, cred azcore.TokenCredential, claims *tokenClaims, scope string, timeout time.Duration) (string, error) {
+	switch auth.mode {
+	case authModeSPSecret, authModeSPCert, authModeManagedIdentity:
+		if auth.clientID != "" {
+			return "client " + auth.clientID, nil
+		}
+	}
+	if claims == nil {
+		tokenCtx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		var err error
+		if claims, err = whoami(tokenCtx, cred, scope); err != nil {
+			return "", fmt.Errorf("failed to identify the caller: %w", err)
+		}
+	}
+	if claims.ObjectID == "" {
+		return "", errors.New("failed to identify the caller: the access token has no oid claim")
+	}
+	return "oid " + claims.ObjectID, nil
+}
+
+// skipCached turns off the tests of cfg that passed within -skip-recent,
+// listed in cfg.recentPasses, and returns a cached pass for each one. A test
+// whose output feeds another, such as encrypt for decrypt, is only skipped
+// along with the tests that use it, and GET only when nothing needs the key
+// it reads; -test-all-algorithms results are per algorithm and always run.
+func skipCached(cfg testConfig) (testConfig, []testResult) {
+	if len(cfg.recentPasses) == 0 {
+		return cfg, nil
+	}
+	var skipped []testResult
+	skip := func(selected *bool, op string, ok bool) {
+		passed, recent := cfg.recentPasses[op]
+		if !*selected || !recent || !ok {
+			return
+		}
+		*selected = false
+		skipped = append(skipped, testResult{
+			Operation:  op,
+			Success:    true,
+			CachedPass: &passed,
+			Notes:      []string{fmt.Sprintf("Not retested: passed at %s, %s ago, within -skip-recent", passed.Format(time.RFC3339), time.Since(passed).Round(time.Second))},
+		})
+	}
+
+	// unused reports whether a test that consumes another's output will not
+	// run: it is not selected, or is skipped too
+	unused := func(selected bool, op string) bool {
+		_, recent := cfg.recentPasses[op]
+		return !selected || recent
+	}
+
+	signatures := !cfg.allAlgorithms && !cfg.roundTrip
+	skip(&cfg.get, opGet, !cfg.keyPolicy.enabled() && !cfg.requireEnabled && cfg.pubkeyOut == "" && !(cfg.allAlgorithms && cfg.usesSignatureAlgorithm()))
+	skip(&cfg.sign, opSign, signatures && unused(cfg.verify, opVerify) && !cfg.localVerify && !cfg.negativeVerify && cfg.signatureOut == "")
+	skip(&cfg.verify, opVerify, signatures)
+	skip(&cfg.encrypt, opEncrypt, unused(cfg.decrypt, opDecrypt))
+	skip(&cfg.decrypt, opDecrypt, true)
+	skip(&cfg.wrap, opWrapKey, unused(cfg.unwrap, opUnwrapKey))
+	skip(&cfg.unwrap, opUnwrapKey, true)
+	skip(&cfg.getRotationPolicy, opRotationPolicyGet, !cfg.rotationExpectation.enabled())
+	return cfg, skipped
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azcertificates"
+)
+
+// runCertificateTests runs the selected certificate-plane tests, passing each
+// result to record as it completes. It stops early and returns false as soon
+// as record returns false.
+func runCertificateTests(ctx context.Context, client *azcertificates.Client, cfg testConfig, record func(testResult) bool) bool {
+	if cfg.certGet {
+		res := testResult{Operation: opCertGet}
+		var cert *certificateInfo
+		err := cfg.timed(ctx, &res, func(ctx context.Context) (err error) {
+			cert, err = doTestGetCertificate(ctx, client, cfg.certName)
+			return err
+		})
+		if err != nil {
+			res.fail(err)
+		} else {
+			res.Success = true
+			res.CertificateID = cert.id
+			res.Thumbprint = cert.thumbprint
+			res.Subject = cert.subject
+			res.Expires = cert.expires
+			if cert.expires != nil && cert.expires.Before(time.Now()) {
+				res.Warnings = append(res.Warnings, fmt.Sprintf("Certificate expired on %s", cert.expires.Format(time.RFC3339)))
+			}
+		}
+		if !record(res) {
+			return false
+		}
+	}
+
+	return true
+}
+
+type certificateInfo struct {
+	id         string
+	thumbprint string
+	subject    string
+	expires    *time.Time
+}
+
+func doTestGetCertificate(ctx context.Context, client *azcertificates.Client, certName string) (*certificateInfo, error) {
+	resp, err := client.GetCertificate(ctx, certName, "", nil)
+	if err != nil {
+		return nil, fmt.Errorf("get certificate operation failed: %w", err)
+	}
+
+	info := &certificateInfo{}
+	if resp.ID != nil {
+		info.id = string(*resp.ID)
+	}
+	if len(resp.X509Thumbprint) > 0 {
+		// Formatted as the portal and az CLI show it
+		info.thumbprint = strings.ToUpper(hex.EncodeToString(resp.X509Thumbprint))
+	}
+	if resp.Attributes != nil && resp.Attributes.Expires != nil {
+		expires := resp.Attributes.Expires.UTC()
+		info.expires = &expires
+	}
+	if resp.Policy != nil && resp.Policy.X509CertificateProperties != nil && resp.Policy.X509CertificateProperties.Subject != nil {
+		info.subject = *resp.Policy.X509CertificateProperties.Subject
+	}
+
+	return info, nil
+}
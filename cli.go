@@ -0,0 +1,236 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Flag groups. A subcommand registers the common flags and those of its own
+// groups; the flat command line, without a subcommand, registers them all.
+const (
+	groupCommon       = "common"
+	groupKeys         = "keys"
+	groupSecrets      = "secrets"
+	groupCertificates = "certificates"
+	groupTiming       = "timing"
+)
+
+var allGroups = []string{groupCommon, groupKeys, groupSecrets, groupCertificates, groupTiming}
+
+// command is a subcommand: the flag groups it accepts, and the flag defaults
+// it changes so that it runs the tests of its plane without extra flags.
+type command struct {
+	name     string
+	synopsis string
+	summary  string
+	groups   []string
+	defaults map[string]bool
+
+	// identityOnly commands log who the credential authenticates as and
+	// exit without running any tests.
+	identityOnly bool
+}
+
+var commands = []command{
+	{
+		name:     "keys test",
+		synopsis: "-vault-url <url> -key-name <name>[,<name>...] [flags]",
+		summary:  "Test key permissions (sign, verify, and get by default)",
+		groups:   []string{groupKeys, groupTiming},
+	},
+	{
+		name:     "secrets test",
+		synopsis: "-vault-url <url> -secret-name <name> [flags]",
+		summary:  "Test secret permissions (get by default)",
+		groups:   []string{groupSecrets, groupTiming},
+		defaults: map[string]bool{"test-secret-get": true},
+	},
+	{
+		name:     "certificates test",
+		synopsis: "-vault-url <url> -cert-name <name> [flags]",
+		summary:  "Test certificate permissions (get by default)",
+		groups:   []string{groupCertificates, groupTiming},
+		defaults: map[string]bool{"test-cert-get": true},
+	},
+	{
+		name:     "benchmark",
+		synopsis: "-vault-url <url> -key-name <name>[,<name>...] [flags]",
+		summary:  "Benchmark key operation latency (same as keys test -benchmark)",
+		groups:   []string{groupKeys, groupTiming},
+		defaults: map[string]bool{"benchmark": true},
+	},
+	{
+		name:         "whoami",
+		synopsis:     "-vault-url <url> [flags]",
+		summary:      "Log the identity the credential authenticates as, without testing",
+		defaults:     map[string]bool{"whoami": true},
+		identityOnly: true,
+	},
+}
+
+// flatCommand is the command line without a subcommand, which accepts every
+// flag as earlier versions did.
+var flatCommand = command{
+	synopsis: "-vault-url <url> -key-name <name>[,<name>...] [flags]",
+	groups:   allGroups,
+}
+
+// selectTests applies -tests and -skip-tests to testFlags, after -skip-all
+// and the -test-* flags. A non-empty allow list enables exactly the tests it
+// names and disables the rest; deny then disables the tests it names. Tests
+// are named by their flag without the "test-" prefix, and tests the command
+// does not accept are rejected.
+func selectTests(fs *flag.FlagSet, testFlags map[string]*bool, allow, deny []string) error {
+	lookup := func(option string, tests []string) (map[string]bool, error) {
+		names := make(map[string]bool)
+		for _, test := range tests {
+			name := "test-" + strings.TrimPrefix(test, "test-")
+			if _, ok := testFlags[name]; ok && fs.Lookup(name) == nil {
+				return nil, fmt.Errorf("-%s: test %q is not run by this command (expected %s)", option, test, strings.Join(testNames(fs, testFlags), ", "))
+			}
+			if _, ok := testFlags[name]; !ok {
+				return nil, fmt.Errorf("-%s: unknown test %q (expected %s)", option, test, strings.Join(testNames(fs, testFlags), ", "))
+			}
+			names[name] = true
+		}
+		return names, nil
+	}
+
+	allowed, err := lookup("tests", allow)
+	if err != nil {
+		return err
+	}
+	denied, err := lookup("skip-tests", deny)
+	if err != nil {
+		return err
+	}
+	for name, enabled := range testFlags {
+		if len(allowed) > 0 {
+			*enabled = allowed[name]
+		}
+		if denied[name] {
+			*enabled = false
+		}
+	}
+	return nil
+}
+
+// testNames returns the sorted names that -tests accepts for fs.
+func testNames(fs *flag.FlagSet, testFlags map[string]*bool) []string {
+	var names []string
+	for name := range testFlags {
+		if fs.Lookup(name) != nil {
+			names = append(names, strings.TrimPrefix(name, "test-"))
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// parseCommand splits the subcommand off args. Without one, the flat command
+// is returned with args unchanged.
+func parseCommand(args []string) (command, []string, error) {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return flatCommand, args, nil
+	}
+	for _, c := range commands {
+		words := strings.Fields(c.name)
+		if len(args) >= len(words) && slices.Equal(args[:len(words)], words) {
+			return c, args[len(words):], nil
+		}
+	}
+	return command{}, nil, fmt.Errorf("unknown command %q", strings.Join(args[:min(len(args), 2)], " "))
+}
+
+// flagSet registers flags on fs for the groups a command accepts. Flags of
+// other groups are not registered and keep their defaults, except that the
+// tests they select are off.
+type flagSet struct {
+	fs  *flag.FlagSet
+	cmd command
+}
+
+func newFlagSet(fs *flag.FlagSet, cmd command) *flagSet {
+	return &flagSet{fs: fs, cmd: cmd}
+}
+
+func (f *flagSet) accepts(group string) bool {
+	return group == groupCommon || slices.Contains(f.cmd.groups, group)
+}
+
+func (f *flagSet) String(group, name, value, usage string) *string {
+	if !f.accepts(group) {
+		return &value
+	}
+	return f.fs.String(name, value, usage)
+}
+
+func (f *flagSet) Bool(group, name string, value bool, usage string) *bool {
+	if d, ok := f.cmd.defaults[name]; ok {
+		value = d
+	}
+	if !f.accepts(group) {
+		return &value
+	}
+	return f.fs.Bool(name, value, usage)
+}
+
+// Test registers a flag that selects a test. The test is off when its group
+// is not accepted.
+func (f *flagSet) Test(group, name string, value bool, usage string) *bool {
+	if !f.accepts(group) {
+		value = false
+		return &value
+	}
+	return f.Bool(group, name, value, usage)
+}
+
+func (f *flagSet) Int(group, name string, value int, usage string) *int {
+	if !f.accepts(group) {
+		return &value
+	}
+	return f.fs.Int(name, value, usage)
+}
+
+func (f *flagSet) Float64(group, name string, value float64, usage string) *float64 {
+	if !f.accepts(group) {
+		return &value
+	}
+	return f.fs.Float64(name, value, usage)
+}
+
+func (f *flagSet) Duration(group, name string, value time.Duration, usage string) *time.Duration {
+	if !f.accepts(group) {
+		return &value
+	}
+	return f.fs.Duration(name, value, usage)
+}
+
+// usage prints help for cmd. The flat command also lists the subcommands.
+func usage(fs *flag.FlagSet, cmd command) {
+	out := fs.Output()
+	prog := os.Args[0]
+	if cmd.name == "" {
+		fmt.Fprintf(out, "Usage: %s %s\n", prog, cmd.synopsis)
+		fmt.Fprintf(out, "       %s <command> [flags]\n\n", prog)
+		fmt.Fprintln(out, "Commands:")
+		for _, c := range commands {
+			fmt.Fprintf(out, "  %-18s %s\n", c.name, c.summary)
+		}
+		fmt.Fprintf(out, "\nRun %s <command> -help for the flags of a command. Without a command, every flag is accepted:\n\n", prog)
+	} else {
+		fmt.Fprintf(out, "Usage: %s %s %s\n\n%s.\n\n", prog, cmd.name, cmd.synopsis, cmd.summary)
+	}
+	fs.PrintDefaults()
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "Exit codes:")
+	fmt.Fprintln(out, "  0  all selected tests passed (skipped tests do not count as failures unless -fail-on-skip is set)")
+	fmt.Fprintln(out, "  1  one or more selected tests failed for any key (-strict stops at the first), were skipped with -fail-on-skip, or the tool could not run (missing flags, credential errors)")
+	fmt.Fprintln(out, "  2  invalid command line flags")
+	fmt.Fprintln(out, "  124  the -deadline passed; tests not completed are reported as skipped")
+	fmt.Fprintln(out, "  130  interrupted (SIGINT or SIGTERM); the results printed are partial")
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azcertificates"
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azkeys"
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azsecrets"
+
+	"azkeyvault-perm-tester/pkg/permtest"
+)
+
+// keyVaultClient is the part of *azkeys.Client the key tests use. The tests
+// depend on it rather than on the concrete client, so that they can be run
+// against a fake that returns canned responses and errors.
+type keyVaultClient interface {
+	// Cryptographic operations and GET, which the permtest package
+	// performs
+	permtest.Client
+
+	// Key management
+	NewListKeyPropertiesPager(options *azkeys.ListKeyPropertiesOptions) *runtime.Pager[azkeys.ListKeyPropertiesResponse]
+	CreateKey(ctx context.Context, name string, parameters azkeys.CreateKeyParameters, options *azkeys.CreateKeyOptions) (azkeys.CreateKeyResponse, error)
+	ImportKey(ctx context.Context, name string, parameters azkeys.ImportKeyParameters, options *azkeys.ImportKeyOptions) (azkeys.ImportKeyResponse, error)
+	UpdateKey(ctx context.Context, name string, version string, parameters azkeys.UpdateKeyParameters, options *azkeys.UpdateKeyOptions) (azkeys.UpdateKeyResponse, error)
+	RotateKey(ctx context.Context, name string, options *azkeys.RotateKeyOptions) (azkeys.RotateKeyResponse, error)
+	GetKeyRotationPolicy(ctx context.Context, name string, options *azkeys.GetKeyRotationPolicyOptions) (azkeys.GetKeyRotationPolicyResponse, error)
+	UpdateKeyRotationPolicy(ctx context.Context, name string, keyRotationPolicy azkeys.KeyRotationPolicy, options *azkeys.UpdateKeyRotationPolicyOptions) (azkeys.UpdateKeyRotationPolicyResponse, error)
+	BackupKey(ctx context.Context, name string, options *azkeys.BackupKeyOptions) (azkeys.BackupKeyResponse, error)
+	RestoreKey(ctx context.Context, parameters azkeys.RestoreKeyParameters, options *azkeys.RestoreKeyOptions) (azkeys.RestoreKeyResponse, error)
+
+	// Deletion and recovery
+	DeleteKey(ctx context.Context, name string, options *azkeys.DeleteKeyOptions) (azkeys.DeleteKeyResponse, error)
+	GetDeletedKey(ctx context.Context, name string, options *azkeys.GetDeletedKeyOptions) (azkeys.GetDeletedKeyResponse, error)
+	RecoverDeletedKey(ctx context.Context, name string, options *azkeys.RecoverDeletedKeyOptions) (azkeys.RecoverDeletedKeyResponse, error)
+	PurgeDeletedKey(ctx context.Context, name string, options *azkeys.PurgeDeletedKeyOptions) (azkeys.PurgeDeletedKeyResponse, error)
+
+	// Managed HSM
+	GetRandomBytes(ctx context.Context, parameters azkeys.GetRandomBytesParameters, options *azkeys.GetRandomBytesOptions) (azkeys.GetRandomBytesResponse, error)
+}
+
+var _ keyVaultClient = (*azkeys.Client)(nil)
+
+// vaultClients creates the clients of each vault of a run, all with one
+// credential, and runs a vault's pre-flight check with it.
+type vaultClients struct {
+	key         func(vault vaultTarget) keyVaultClient
+	secret      func(vault vaultTarget) *azsecrets.Client
+	certificate func(vault vaultTarget) *azcertificates.Client
+	check       func(vault vaultTarget, client keyVaultClient) error
+}
+
+// clientSettings are what every Key Vault client of a run shares besides
+// its credential.
+type clientSettings struct {
+	env       cloudEnvironment
+	transport policy.Transporter
+	appID     string
+	dryRun    bool
+
+	// explicitScope skips the check that the vault's challenge names the
+	// requested token scope, for -scope
+	explicitScope bool
+
+	// timeout bounds the token request of the pre-flight check
+	timeout time.Duration
+}
+
+// newVaultClients returns the clients of each vault with cred. A client that
+// cannot be created ends the run.
+func newVaultClients(ctx context.Context, cred azcore.TokenCredential, s clientSettings) vaultClients {
+	options := func() azcore.ClientOptions {
+		return clientOptions(s.env.config, s.transport, s.appID, s.dryRun)
+	}
+	return vaultClients{
+		key: func(vault vaultTarget) keyVaultClient {
+			client, err := azkeys.NewClient(vault.url, cred, &azkeys.ClientOptions{
+				ClientOptions:                        options(),
+				DisableChallengeResourceVerification: s.explicitScope,
+			})
+			if err != nil {
+				fatal("Failed to create Key Vault client", "error", err)
+			}
+			return client
+		},
+		secret: func(vault vaultTarget) *azsecrets.Client {
+			client, err := azsecrets.NewClient(vault.url, cred, &azsecrets.ClientOptions{
+				ClientOptions:                        options(),
+				DisableChallengeResourceVerification: s.explicitScope,
+			})
+			if err != nil {
+				fatal("Failed to create Key Vault secrets client", "error", err)
+			}
+			return client
+		},
+		certificate: func(vault vaultTarget) *azcertificates.Client {
+			client, err := azcertificates.NewClient(vault.url, cred, &azcertificates.ClientOptions{
+				ClientOptions:                        options(),
+				DisableChallengeResourceVerification: s.explicitScope,
+			})
+			if err != nil {
+				fatal("Failed to create Key Vault certificates client", "error", err)
+			}
+			return client
+		},
+		// The pre-flight check also logs the vault's recovery settings
+		check: func(vault vaultTarget, client keyVaultClient) error {
+			recovery, note, err := preflight(ctx, cred, client, s.env, vault, s.timeout)
+			if err != nil {
+				return err
+			}
+			slog.Debug("Pre-flight check passed", "vault", vault.url)
+			if recovery.known() {
+				slog.Info("Vault recovery settings", "vault", vault.url, "settings", recovery.describe())
+			} else {
+				slog.Info("Vault soft-delete and purge protection settings are unknown", "vault", vault.url, "reason", note)
+			}
+			return nil
+		},
+	}
+}
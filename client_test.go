@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azkeys"
+)
+
+// fakeKID is the key ID fakeClient reports using.
+const fakeKID = "https://fake.vault.azure.net/keys/fake-key/0123456789abcdef"
+
+// fakeClient is a keyVaultClient that answers the key tests from canned
+// responses instead of Key Vault. Its encryption and wrapping return their
+// input unchanged, so that decrypt and unwrap round trips pass, and verify
+// accepts every signature. The management and deletion methods are left to
+// the nil embedded interface and panic if called.
+type fakeClient struct {
+	keyVaultClient
+
+	// key is what GetKey returns; nil means an RSA-HSM key
+	key *azkeys.JSONWebKey
+
+	// keys are the names of the keys the vault lists
+	keys []string
+
+	// errs fails the operations it names, by op constant, with its error
+	errs map[string]error
+
+	// hang blocks the operations it names until their context is done,
+	// as a request to an unresponsive vault would
+	hang map[string]bool
+
+	// wrong makes verify reject every signature, and decrypt and unwrap
+	// return a corrupted copy of their input
+	wrong map[string]bool
+
+	// mu guards calls, since keys may be tested concurrently
+	mu sync.Mutex
+
+	// calls records the operations attempted, in order
+	calls []string
+
+	// signatures records the signatures sent to verify, in order
+	signatures [][]byte
+}
+
+var _ keyVaultClient = (*fakeClient)(nil)
+
+// call records op and returns its canned error, after blocking for the
+// operations in hang.
+func (f *fakeClient) call(ctx context.Context, op string) error {
+	f.mu.Lock()
+	f.calls = append(f.calls, op)
+	f.mu.Unlock()
+	if f.hang[op] {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	return f.errs[op]
+}
+
+// called reports how often op was attempted.
+func (f *fakeClient) called(op string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for _, c := range f.calls {
+		if c == op {
+			n++
+		}
+	}
+	return n
+}
+
+// output returns data as the operation op produces it: unchanged, or with
+// its first byte flipped for the operations in wrong.
+func (f *fakeClient) output(op string, data []byte) []byte {
+	out := bytes.Clone(data)
+	if f.wrong[op] && len(out) > 0 {
+		out[0] ^= 0xff
+	}
+	return out
+}
+
+func fakeKeyID() *azkeys.ID {
+	kid := azkeys.ID(fakeKID)
+	return &kid
+}
+
+func (f *fakeClient) GetKey(ctx context.Context, name string, version string, options *azkeys.GetKeyOptions) (azkeys.GetKeyResponse, error) {
+	if err := f.call(ctx, opGet); err != nil {
+		return azkeys.GetKeyResponse{}, err
+	}
+	kty := azkeys.KeyTypeRSAHSM
+	key := azkeys.JSONWebKey{Kty: &kty, N: make([]byte, 256), E: []byte{1, 0, 1}}
+	if f.key != nil {
+		key = *f.key
+	}
+	key.KID = fakeKeyID()
+	return azkeys.GetKeyResponse{KeyBundle: azkeys.KeyBundle{Key: &key}}, nil
+}
+
+func (f *fakeClient) Sign(ctx context.Context, name string, version string, parameters azkeys.SignParameters, options *azkeys.SignOptions) (azkeys.SignResponse, error) {
+	if err := f.call(ctx, opSign); err != nil {
+		return azkeys.SignResponse{}, err
+	}
+	return azkeys.SignResponse{KeyOperationResult: azkeys.KeyOperationResult{KID: fakeKeyID(), Result: bytes.Repeat([]byte{0x5a}, 256)}}, nil
+}
+
+func (f *fakeClient) Verify(ctx context.Context, name string, version string, parameters azkeys.VerifyParameters, options *azkeys.VerifyOptions) (azkeys.VerifyResponse, error) {
+	if err := f.call(ctx, opVerify); err != nil {
+		return azkeys.VerifyResponse{}, err
+	}
+	f.mu.Lock()
+	f.signatures = append(f.signatures, parameters.Signature)
+	f.mu.Unlock()
+	valid := !f.wrong[opVerify]
+	return azkeys.VerifyResponse{KeyVerifyResult: azkeys.KeyVerifyResult{Value: &valid}}, nil
+}
+
+func (f *fakeClient) Encrypt(ctx context.Context, name string, version string, parameters azkeys.KeyOperationParameters, options *azkeys.EncryptOptions) (azkeys.EncryptResponse, error) {
+	if err := f.call(ctx, opEncrypt); err != nil {
+		return azkeys.EncryptResponse{}, err
+	}
+	return azkeys.EncryptResponse{KeyOperationResult: azkeys.KeyOperationResult{KID: fakeKeyID(), Result: bytes.Clone(parameters.Value), IV: parameters.IV}}, nil
+}
+
+func (f *fakeClient) Decrypt(ctx context.Context, name string, version string, parameters azkeys.KeyOperationParameters, options *azkeys.DecryptOptions) (azkeys.DecryptResponse, error) {
+	if err := f.call(ctx, opDecrypt); err != nil {
+		return azkeys.DecryptResponse{}, err
+	}
+	return azkeys.DecryptResponse{KeyOperationResult: azkeys.KeyOperationResult{KID: fakeKeyID(), Result: f.output(opDecrypt, parameters.Value)}}, nil
+}
+
+func (f *fakeClient) WrapKey(ctx context.Context, name string, version string, parameters azkeys.KeyOperationParameters, options *azkeys.WrapKeyOptions) (azkeys.WrapKeyResponse, error) {
+	if err := f.call(ctx, opWrapKey); err != nil {
+		return azkeys.WrapKeyResponse{}, err
+	}
+	return azkeys.WrapKeyResponse{KeyOperationResult: azkeys.KeyOperationResult{KID: fakeKeyID(), Result: bytes.Clone(parameters.Value)}}, nil
+}
+
+func (f *fakeClient) UnwrapKey(ctx context.Context, name string, version string, parameters azkeys.KeyOperationParameters, options *azkeys.UnwrapKeyOptions) (azkeys.UnwrapKeyResponse, error) {
+	if err := f.call(ctx, opUnwrapKey); err != nil {
+		return azkeys.UnwrapKeyResponse{}, err
+	}
+	return azkeys.UnwrapKeyResponse{KeyOperationResult: azkeys.KeyOperationResult{KID: fakeKeyID(), Result: f.output(opUnwrapKey, parameters.Value)}}, nil
+}
+
+func (f *fakeClient) NewListKeyPropertiesPager(options *azkeys.ListKeyPropertiesOptions) *runtime.Pager[azkeys.ListKeyPropertiesResponse] {
+	return runtime.NewPager(runtime.PagingHandler[azkeys.ListKeyPropertiesResponse]{
+		More: func(page azkeys.ListKeyPropertiesResponse) bool {
+			return false
+		},
+		Fetcher: func(ctx context.Context, page *azkeys.ListKeyPropertiesResponse) (azkeys.ListKeyPropertiesResponse, error) {
+			if err := f.call(ctx, opList); err != nil {
+				return azkeys.ListKeyPropertiesResponse{}, err
+			}
+			var resp azkeys.ListKeyPropertiesResponse
+			for _, name := range f.keys {
+				kid := azkeys.ID("https://fake.vault.azure.net/keys/" + name)
+				resp.Value = append(resp.Value, &azkeys.KeyProperties{KID: &kid})
+			}
+			return resp, nil
+		},
+	})
+}
+
+// responseError builds the *azcore.ResponseError the SDK returns for a Key
+// Vault error response with the given status, error codes, and message.
+func responseError(status int, code, innerCode, message string) error {
+	body := fmt.Sprintf(`{"error":{"code":%q,"message":%q,"innererror":{"code":%q}}}`, code, message, innerCode)
+	req, err := http.NewRequest(http.MethodPost, fakeKID+"/sign", nil)
+	if err != nil {
+		panic(err)
+	}
+	req.Header.Set("x-ms-client-request-id", "fake-client-request-id")
+	resp := &http.Response{
+		Status:     fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode: status,
+		Header:     http.Header{"X-Ms-Request-Id": {"fake-request-id"}},
+		Body:       io.NopCloser(strings.NewReader(body)),
+		Request:    req,
+	}
+	return runtime.NewResponseError(resp)
+}
+
+// forbidden is the 403 Key Vault returns to an identity without the RBAC
+// role an operation needs.
+func forbidden() error {
+	return responseError(http.StatusForbidden, "Forbidden", "ForbiddenByRbac", "Caller is not authorized to perform action on resource.")
+}
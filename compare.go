@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+)
+
+// compareIdentity is the second identity of -compare-with: how to
+// authenticate it, the label of its column, and its credential once built.
+type compareIdentity struct {
+	auth  authConfig
+	label string
+	cred  azcore.TokenCredential
+}
+
+// parseCompareWith parses a -compare-with value, comma-separated key=value
+// settings such as "auth-mode=sp-cert,client-id=...,cert-path=ci.pem".
+// Settings not given are taken from base, the primary identity, except the
+// client ID and the secrets, which belong to one identity only. Secrets are
+// read from the environment variables named by client-secret-env and
+// cert-password-env, so that they stay out of the command line.
+func parseCompareWith(value string, base authConfig) (compareIdentity, error) {
+	id := compareIdentity{auth: base}
+	id.auth.clientID, id.auth.clientSecret, id.auth.certPath, id.auth.certPassword = "", "", "", ""
+
+	for _, setting := range splitList(value) {
+		key, val, ok := strings.Cut(setting, "=")
+		if !ok || val == "" {
+			return id, fmt.Errorf("invalid -compare-with setting %q: expected key=value", setting)
+		}
+		switch key {
+		case "auth-mode":
+			id.auth.mode = val
+		case "tenant-id":
+			id.auth.tenantID = val
+		case "client-id":
+			id.auth.clientID = val
+		case "cert-path":
+			id.auth.certPath = val
+		case "redirect-url":
+			id.auth.redirectURL = val
+		case "client-secret-env":
+			id.auth.clientSecret = os.Getenv(val)
+			if id.auth.clientSecret == "" {
+				return id, fmt.Errorf("-compare-with client-secret-env names %s, which is not set", val)
+			}
+		case "cert-password-env":
+			id.auth.certPassword = os.Getenv(val)
+		case "label":
+			id.label = val
+		default:
+			return id, fmt.Errorf("unknown -compare-with setting %q (expected auth-mode, tenant-id, client-id, cert-path, redirect-url, client-secret-env, cert-password-env, or label)", key)
+		}
+	}
+	if id.auth.mode == authModeSPSecret && id.auth.clientSecret == "" {
+		return id, fmt.Errorf("-compare-with auth-mode=sp-secret needs client-secret-env, the environment variable holding the secret")
+	}
+	if id.label == "" {
+		id.label = identityLabel(id.auth)
+	}
+	return id, nil
+}
+
+// identityLabel names an identity for a comparison column: its auth mode,
+// and the client ID that distinguishes service principals and user-assigned
+// managed identities, e.g. "sp-secret 11111111-...".
+func identityLabel(auth authConfig) string {
+	if auth.clientID == "" {
+		return auth.mode
+	}
+	return auth.mode + " " + auth.clientID
+}
+
+// comparisonRow is one test in a -compare-with comparison, with its status
+// under each identity: a resultStatus value, or "" when the test did not run
+// for that identity.
+type comparisonRow struct {
+	vault     string
+	name      string
+	operation string
+	first     string
+	second    string
+}
+
+func (c comparisonRow) differs() bool {
+	return c.first != c.second
+}
+
+// compareReports pairs the results of two runs of the same tests by vault,
+// summary row, and result label, in the order of the first run, followed by
+// anything only the second run has.
+func compareReports(first, second []*runReport) []comparisonRow {
+	type rowKey struct{ vault, name, operation string }
+	var rows []comparisonRow
+	index := make(map[rowKey]int)
+	add := func(r *runReport, res testResult, status func(*comparisonRow) *string) {
+		k := rowKey{r.VaultURL, summaryRowName(r), resultLabel(res)}
+		i, ok := index[k]
+		if !ok {
+			i = len(rows)
+			index[k] = i
+			rows = append(rows, comparisonRow{vault: k.vault, name: k.name, operation: k.operation})
+		}
+		*status(&rows[i]) = resultStatus(res)
+	}
+	for _, r := range first {
+		for _, res := range r.Results {
+			add(r, res, func(c *comparisonRow) *string { return &c.first })
+		}
+	}
+	for _, r := range second {
+		for _, res := range r.Results {
+			add(r, res, func(c *comparisonRow) *string { return &c.second })
+		}
+	}
+	return rows
+}
+
+// writeComparison prints the comparison as a table with a column per
+// identity. Rows whose outcome differs are marked with an asterisk, and a
+// closing line counts them. It returns the number of differing rows.
+func writeComparison(w io.Writer, firstLabel, secondLabel string, rows []comparisonRow, showVault bool) (int, error) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	line := func(marker, vault, name, operation, first, second string) {
+		cells := []string{marker, name, operation, first, second}
+		if showVault {
+			cells = []string{marker, vault, name, operation, first, second}
+		}
+		fmt.Fprintln(tw, strings.Join(cells, "\t"))
+	}
+	line("", "VAULT", "TARGET", "OPERATION", firstLabel, secondLabel)
+
+	differ := 0
+	for _, row := range rows {
+		marker := ""
+		if row.differs() {
+			marker = "*"
+			differ++
+		}
+		line(marker, row.vault, row.name, row.operation, statusOrNotRun(row.first), statusOrNotRun(row.second))
+	}
+	if err := tw.Flush(); err != nil {
+		return differ, err
+	}
+
+	var err error
+	if differ == 0 {
+		_, err = fmt.Fprintf(w, "\nBoth identities have the same access: all %d operations had the same outcome\n", len(rows))
+	} else {
+		_, err = fmt.Fprintf(w, "\n* %d of %d operations had a different outcome for %s and %s\n", differ, len(rows), firstLabel, secondLabel)
+	}
+	return differ, err
+}
+
+func statusOrNotRun(status string) string {
+	if status == "" {
+		return "not run"
+	}
+	return status
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"slices"
+	"sync"
+)
+
+// runKeysConcurrently calls run for each key name on at most n goroutines at
+// a time and returns the reports sorted by key name, so the output does not
+// depend on the order in which keys finish. run may return nil for a key it
+// chose not to test, which is left out of the result. Key Vault clients are
+// safe for concurrent use, so every call may share one.
+func runKeysConcurrently(names []string, n int, run func(name string) *runReport) []*runReport {
+	sorted := slices.Clone(names)
+	slices.Sort(sorted)
+
+	reports := make([]*runReport, len(sorted))
+	sem := make(chan struct{}, n)
+	var wg sync.WaitGroup
+	for i, name := range sorted {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			reports[i] = run(name)
+		}()
+	}
+	wg.Wait()
+
+	return slices.DeleteFunc(reports, func(r *runReport) bool { return r == nil })
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// fileConfig is a test profile loaded with -config. Each field maps onto the
+// command line flag of the same name, and flags given on the command line or
+// in the environment take precedence. Credentials such as client secrets are
+// deliberately not supported so that profiles can be committed to source
+// control.
+type fileConfig struct {
+	VaultURL            string   `yaml:"vaultUrl" json:"vaultUrl"`
+	VaultURLs           []string `yaml:"vaultUrls" json:"vaultUrls"`
+	KeyNames            []string `yaml:"keyNames" json:"keyNames"`
+	KeyVersion          string   `yaml:"keyVersion" json:"keyVersion"`
+	KeyID               string   `yaml:"keyId" json:"keyId"`
+	Algorithm           string   `yaml:"algorithm" json:"algorithm"`
+	AutoAlgorithm       *bool    `yaml:"autoAlgorithm" json:"autoAlgorithm"`
+	EncryptionAlgorithm string   `yaml:"encryptionAlgorithm" json:"encryptionAlgorithm"`
+	WrapAlgorithm       string   `yaml:"wrapAlgorithm" json:"wrapAlgorithm"`
+	SecretName          string   `yaml:"secretName" json:"secretName"`
+	CertName            string   `yaml:"certName" json:"certName"`
+	AuthMode            string   `yaml:"authMode" json:"authMode"`
+	TenantID            string   `yaml:"tenantId" json:"tenantId"`
+	ClientID            string   `yaml:"clientId" json:"clientId"`
+	CertPath            string   `yaml:"certPath" json:"certPath"`
+	RedirectURL         string   `yaml:"redirectUrl" json:"redirectUrl"`
+	ProxyURL            string   `yaml:"proxyUrl" json:"proxyUrl"`
+	CACert              string   `yaml:"caCert" json:"caCert"`
+	Cloud               string   `yaml:"cloud" json:"cloud"`
+	Gov                 *bool    `yaml:"gov" json:"gov"`
+	HSM                 *bool    `yaml:"hsm" json:"hsm"`
+	Output              string   `yaml:"output" json:"output"`
+	OutputFile          string   `yaml:"outputFile" json:"outputFile"`
+	AppID               string   `yaml:"appId" json:"appId"`
+	Scope               string   `yaml:"scope" json:"scope"`
+	Timeout             string   `yaml:"timeout" json:"timeout"`
+	Deadline            string   `yaml:"deadline" json:"deadline"`
+	MaxRetries          *int     `yaml:"maxRetries" json:"maxRetries"`
+	RateLimit           *float64 `yaml:"rateLimit" json:"rateLimit"`
+	SkipRecent          string   `yaml:"skipRecent" json:"skipRecent"`
+	Strict              *bool    `yaml:"strict" json:"strict"`
+	ContinueOnAuthError *bool    `yaml:"continueOnAuthError" json:"continueOnAuthError"`
+	FailOnSkip          *bool    `yaml:"failOnSkip" json:"failOnSkip"`
+	Confirm             *bool    `yaml:"confirm" json:"confirm"`
+
+	// Tests lists the tests to run by the name of their -test-* flag without
+	// the prefix, e.g. "sign" or "secret-get". Tests not listed are disabled.
+	Tests []string `yaml:"tests" json:"tests"`
+
+	// SkipTests lists tests not to run, as -skip-tests does.
+	SkipTests []string `yaml:"skipTests" json:"skipTests"`
+}
+
+// loadConfigFile reads a YAML or JSON profile. Files ending in .json are
+// parsed as JSON; anything else as YAML. Unknown keys are rejected so that
+// typos don't silently fall back to defaults.
+func loadConfigFile(path string) (*fileConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	cfg := &fileConfig{}
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(cfg)
+	} else {
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		err = dec.Decode(cfg)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// apply sets the flags of fs the profile configures, skipping any in
+// explicit, the flags given on the command line or in the environment.
+// testFlags are the flags that select tests. Settings for flags the command
+// does not accept are ignored, so that one profile can serve several
+// subcommands.
+func (c *fileConfig) apply(fs *flag.FlagSet, explicit map[string]bool, testFlags map[string]*bool) error {
+	values := map[string]string{
+		"vault-url":            strings.Join(append(splitList(c.VaultURL), c.VaultURLs...), ","),
+		"key-name":             strings.Join(c.KeyNames, ","),
+		"skip-tests":           strings.Join(c.SkipTests, ","),
+		"key-version":          c.KeyVersion,
+		"key-id":               c.KeyID,
+		"algorithm":            c.Algorithm,
+		"encryption-algorithm": c.EncryptionAlgorithm,
+		"wrap-algorithm":       c.WrapAlgorithm,
+		"secret-name":          c.SecretName,
+		"cert-name":            c.CertName,
+		"auth-mode":            c.AuthMode,
+		"tenant-id":            c.TenantID,
+		"client-id":            c.ClientID,
+		"cert-path":            c.CertPath,
+		"redirect-url":         c.RedirectURL,
+		"proxy-url":            c.ProxyURL,
+		"ca-cert":              c.CACert,
+		"cloud":                c.Cloud,
+		"output":               c.Output,
+		"output-file":          c.OutputFile,
+		"app-id":               c.AppID,
+		"scope":                c.Scope,
+		"timeout":              c.Timeout,
+		"deadline":             c.Deadline,
+		"skip-recent":          c.SkipRecent,
+	}
+	for name, value := range map[string]*bool{
+		"auto-algorithm":         c.AutoAlgorithm,
+		"gov":                    c.Gov,
+		"hsm":                    c.HSM,
+		"strict":                 c.Strict,
+		"continue-on-auth-error": c.ContinueOnAuthError,
+		"fail-on-skip":           c.FailOnSkip,
+		"confirm":                c.Confirm,
+	} {
+		if value != nil {
+			values[name] = strconv.FormatBool(*value)
+		}
+	}
+	if c.MaxRetries != nil {
+		values["max-retries"] = strconv.Itoa(*c.MaxRetries)
+	}
+	if c.RateLimit != nil {
+		values["rate-limit"] = strconv.FormatFloat(*c.RateLimit, 'f', -1, 64)
+	}
+
+	if len(c.Tests) > 0 {
+		selected := make(map[string]bool)
+		for _, test := range c.Tests {
+			name := "test-" + test
+			if _, ok := testFlags[name]; !ok {
+				return fmt.Errorf("config file: unknown test %q", test)
+			}
+			selected[name] = true
+		}
+		for name := range testFlags {
+			values[name] = strconv.FormatBool(selected[name])
+		}
+	}
+
+	for name, value := range values {
+		if value == "" || explicit[name] || fs.Lookup(name) == nil {
+			continue
+		}
+		if err := fs.Set(name, value); err != nil {
+			return fmt.Errorf("config file: invalid %s: %w", name, err)
+		}
+	}
+	return nil
+}
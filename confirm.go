@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// mutatingTests describes, in the order they run, what each test that
+// modifies a vault does, for the -confirm prompt.
+var mutatingTests = []struct {
+	flag        string
+	description string
+}{
+	{"test-create", "create a temporary key"},
+	{"test-rotate", "rotate the temporary key, adding a version"},
+	{"test-set-rotation-policy", "set a rotation policy on the temporary key"},
+	{"test-update", "add and remove a tag on the temporary key"},
+	{"test-delete", "delete the temporary key"},
+	{"test-recover", "recover the deleted temporary key"},
+	{"test-purge", "PERMANENTLY PURGE the deleted temporary key"},
+	{"test-import", "import a locally generated temporary key"},
+	{"test-restore", "restore the backup of each tested key (a no-op while the key exists)"},
+}
+
+// selectedMutations returns the descriptions of the selected mutating tests,
+// each labelled with its flag.
+func selectedMutations(testFlags map[string]*bool) []string {
+	var selected []string
+	for _, test := range mutatingTests {
+		if enabled, ok := testFlags[test.flag]; ok && *enabled {
+			selected = append(selected, fmt.Sprintf("%s (-%s)", test.description, test.flag))
+		}
+	}
+	return selected
+}
+
+// confirmMutations lists the mutations on w, with the vaults they will run
+// against, and reads the answer from r. It returns an error unless the
+// answer is "yes".
+func confirmMutations(r io.Reader, w io.Writer, mutations []string, vaults []vaultTarget) error {
+	fmt.Fprintln(w, "The following tests modify the vault:")
+	for _, m := range mutations {
+		fmt.Fprintf(w, "  - %s\n", m)
+	}
+	fmt.Fprintln(w, "They will run against:")
+	for _, vault := range vaults {
+		fmt.Fprintf(w, "  - %s\n", vault.url)
+	}
+	fmt.Fprint(w, "Type yes to continue: ")
+
+	answer, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("failed to read confirmation: %w", err)
+	}
+	if strings.TrimSpace(answer) != "yes" {
+		return errors.New("mutating tests were not confirmed; nothing was changed")
+	}
+	return nil
+}
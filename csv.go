@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+)
+
+// csvHeader names the columns written by csvReporter.
+var csvHeader = []string{"vault", "key", "operation", "result", "error", "durationMs", "protection"}
+
+// csvReporter writes one row per test as it completes, for importing into
+// spreadsheets. The key column holds the summary row name, so vault-wide,
+// secret, and certificate tests are labelled too.
+type csvReporter struct {
+	w *csv.Writer
+
+	// failuresOnly drops passed and skipped results, for -quiet.
+	failuresOnly bool
+
+	wroteHeader bool
+	vault       string
+	row         string
+}
+
+func newCSVReporter(w io.Writer, failuresOnly bool) *csvReporter {
+	return &csvReporter{w: csv.NewWriter(w), failuresOnly: failuresOnly}
+}
+
+func (c *csvReporter) beginKey(r *runReport) {
+	if !c.wroteHeader {
+		c.write(csvHeader)
+		c.wroteHeader = true
+	}
+	c.vault = r.VaultURL
+	c.row = summaryRowName(r)
+}
+
+func (c *csvReporter) result(res testResult) {
+	if c.failuresOnly && !isFailure(res) {
+		return
+	}
+
+	operation := res.Operation
+	if res.Algorithm != "" {
+		operation += " " + res.Algorithm
+	}
+	var errMsg, duration string
+	if res.Error != nil {
+		errMsg = *res.Error
+	}
+	if res.Duration > 0 {
+		duration = strconv.FormatFloat(res.DurationMs, 'f', -1, 64)
+	}
+	c.write([]string{c.vault, c.row, operation, resultStatus(res), errMsg, duration, res.ProtectionLevel})
+}
+
+func (c *csvReporter) endKey(r *runReport) {}
+
+func (c *csvReporter) finish(reports []*runReport) error {
+	if !c.wroteHeader {
+		c.write(csvHeader)
+	}
+	return c.w.Error()
+}
+
+// write emits a row and flushes it, so rows stream as tests complete.
+// Errors are kept by the csv.Writer and reported by finish.
+func (c *csvReporter) write(row []string) {
+	_ = c.w.Write(row)
+	c.w.Flush()
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"errors"
+)
+
+// exitDeadline is the exit code of a run that -deadline cut short, following
+// timeout(1).
+const exitDeadline = 124
+
+// errRunDeadline is the cause of the run's context once -deadline passes,
+// which tells it apart from an interrupt.
+var errRunDeadline = errors.New("run deadline reached")
+
+// deadlineReached reports whether -deadline has expired ctx.
+func deadlineReached(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), errRunDeadline)
+}
+
+// cutShort reports whether res, recorded after the -deadline passed, is a
+// test the deadline stopped: one that timed out, or was skipped because a
+// test it depends on did. Results Key Vault answered stand.
+func cutShort(res testResult) bool {
+	return res.ErrorCategory == categoryTimeout || (res.Skipped && !res.NotApplicable && !res.DeadlineExceeded)
+}
+
+// skipDeadline records a test that -deadline stopped before it completed, in
+// place of the timeout it failed with. Every test selected after the deadline
+// passes ends this way, without a request being sent.
+func (r *testResult) skipDeadline() {
+	r.Success = false
+	r.Error = nil
+	r.ErrorCategory, r.StatusCode, r.ErrorCode = "", 0, ""
+	r.AuthorizationModel, r.Remediation = "", ""
+	r.RequestID, r.ClientRequestID = "", ""
+	r.Notes = nil
+	r.DeadlineExceeded = true
+	r.skip("Not completed: the run reached its -deadline")
+}
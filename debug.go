@@ -0,0 +1,49 @@
+package main
+
+import (
+	"log/slog"
+	"strings"
+
+	azlog "github.com/Azure/azure-sdk-for-go/sdk/azcore/log"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+)
+
+// debugAllowedHeaders are logged unredacted in addition to the SDK's default
+// allowlist. They help diagnose authentication and routing problems and
+// carry no credentials.
+var debugAllowedHeaders = []string{
+	"WWW-Authenticate",
+	"x-ms-keyvault-region",
+	"x-ms-keyvault-service-version",
+	"x-ms-keyvault-network-info",
+	"x-ms-keyvault-rbac-assignment-id",
+}
+
+// listenToSDK routes the SDK's log events to logger. The credential that
+// authenticated, which for DefaultAzureCredential is only known once a
+// token has been acquired, is always logged at info level. With httpLogging,
+// request, response, and other authentication events are logged at debug
+// level. The SDK's logging policy redacts the Authorization header, every
+// header not on its allowlist, and query parameter values, and never logs
+// bodies, so tokens and secret values are not written.
+func listenToSDK(logger *slog.Logger, httpLogging bool) {
+	events := []azlog.Event{azidentity.EventAuthentication}
+	if httpLogging {
+		events = append(events, azlog.EventRequest, azlog.EventResponse, azlog.EventResponseError)
+	}
+	azlog.SetEvents(events...)
+	azlog.SetListener(func(event azlog.Event, msg string) {
+		msg = strings.TrimRight(msg, "\n")
+		if event == azidentity.EventAuthentication {
+			// The chained credential reports e.g. "DefaultAzureCredential
+			// authenticated with AzureCLICredential"
+			if _, name, ok := strings.Cut(msg, " authenticated with "); ok {
+				logger.Info("Authenticated", "credential", name)
+				return
+			}
+		}
+		if httpLogging {
+			logger.Debug(msg, "event", string(event))
+		}
+	})
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+// dryRunError is returned in place of a response when -dry-run intercepts a
+// request. It describes the request that would have been sent.
+type dryRunError struct {
+	method string
+	url    string
+	body   string
+}
+
+func (e *dryRunError) Error() string {
+	return "dry run: " + e.describe()
+}
+
+func (e *dryRunError) describe() string {
+	if e.body == "" {
+		return fmt.Sprintf("would send %s %s", e.method, e.url)
+	}
+	return fmt.Sprintf("would send %s %s with body %s", e.method, e.url, e.body)
+}
+
+// dryRunPolicy stops every request before it reaches authentication or the
+// network. It runs as a per-call policy, so no token is acquired either.
+type dryRunPolicy struct{}
+
+func (dryRunPolicy) Do(req *policy.Request) (*http.Response, error) {
+	raw := req.Raw()
+	e := &dryRunError{method: raw.Method, url: raw.URL.String()}
+	if body := req.Body(); body != nil {
+		data, err := io.ReadAll(body)
+		if err != nil {
+			return nil, fmt.Errorf("dry run: failed to read request body: %w", err)
+		}
+		e.body = string(data)
+	}
+	return nil, e
+}
+
+// isDryRun reports whether err is a request intercepted by -dry-run.
+func isDryRun(err error) bool {
+	var e *dryRunError
+	return errors.As(err, &e)
+}
+
+// dryRunPlaceholder stands in for the output of an operation that -dry-run
+// intercepted, so that dependent tests can still show the request they would
+// send. It returns nil for any other error.
+func dryRunPlaceholder(err error, size int) []byte {
+	if !isDryRun(err) {
+		return nil
+	}
+	return make([]byte, size)
+}
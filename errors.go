@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"syscall"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+)
+
+// Error categories reported for failed operations.
+const (
+	categoryForbidden    = "Forbidden"
+	categoryUnauthorized = "Unauthorized"
+	categoryNotFound     = "NotFound"
+	categoryThrottled    = "Throttled"
+	categoryTimeout      = "Timeout"
+	categoryNetwork      = "Network"
+	categoryOther        = "Other"
+
+	// categoryMismatch marks an operation that was permitted but whose
+	// result was wrong, such as a round trip that returned different bytes.
+	categoryMismatch = "Mismatch"
+
+	// categoryPrecondition marks an operation that was not attempted because
+	// the key cannot be used, for -require-enabled.
+	categoryPrecondition = "PreconditionFailed"
+
+	// categoryPolicy marks a key that Key Vault could use but that is weaker
+	// than -min-rsa-bits or -allowed-curves require.
+	categoryPolicy = "PolicyViolation"
+)
+
+// classifiedError describes a failed operation in terms of the HTTP response
+// Key Vault returned for it.
+type classifiedError struct {
+	category   string
+	statusCode int    // zero when no HTTP response was received
+	errorCode  string // Azure error code, e.g. Forbidden or KeyNotFound
+	message    string
+
+	// authModel is the authorization model that denied a 403, if it could
+	// be inferred from the response.
+	authModel string
+
+	// requestID is the x-ms-request-id Key Vault assigned the failed
+	// request, and clientRequestID the x-ms-client-request-id it was sent
+	// with; Azure support asks for both.
+	requestID       string
+	clientRequestID string
+}
+
+// classifyError inspects err for an *azcore.ResponseError and categorizes it
+// by status code. Operations that ran out of time are categorized as Timeout,
+// requests that never reached Key Vault as Network, and other errors without
+// an HTTP response as Other.
+func classifyError(err error) classifiedError {
+	c := classifiedError{category: categoryOther, message: err.Error()}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		c.category = categoryTimeout
+		return c
+	}
+	if cause, ok := networkCause(err); ok {
+		c.category = categoryNetwork
+		c.message = cause
+		return c
+	}
+
+	var respErr *azcore.ResponseError
+	if !errors.As(err, &respErr) {
+		return c
+	}
+
+	c.statusCode = respErr.StatusCode
+	c.errorCode = respErr.ErrorCode
+	if resp := respErr.RawResponse; resp != nil {
+		c.requestID = resp.Header.Get("x-ms-request-id")
+		if resp.Request != nil {
+			c.clientRequestID = resp.Request.Header.Get("x-ms-client-request-id")
+		}
+	}
+	switch respErr.StatusCode {
+	case http.StatusUnauthorized:
+		c.category = categoryUnauthorized
+	case http.StatusForbidden:
+		c.category = categoryForbidden
+	case http.StatusNotFound:
+		c.category = categoryNotFound
+	case http.StatusTooManyRequests:
+		c.category = categoryThrottled
+	}
+
+	// The SDK's error text includes the full request and response dump; the
+	// message from the response body is far more readable.
+	msg, innerCode := responseErrorDetails(respErr)
+	if msg != "" {
+		c.message = msg
+	}
+	if c.category == categoryForbidden {
+		c.authModel = inferAuthModel(innerCode, msg)
+	}
+	return c
+}
+
+// networkCause describes why a request could not reach the vault at all: a
+// host name that does not resolve, a refused connection, or a failed TLS
+// handshake. These are not permission problems, and the SDK's error text
+// buries the cause. It reports false for any other error.
+func networkCause(err error) (string, bool) {
+	var dnsErr *net.DNSError
+	var certErr *tls.CertificateVerificationError
+	var authorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var recordErr tls.RecordHeaderError
+	var alertErr tls.AlertError
+	var opErr *net.OpError
+	switch {
+	case errors.As(err, &dnsErr):
+		return fmt.Sprintf("host %s could not be resolved (%s); check the vault name and DNS settings", dnsErr.Name, dnsErr.Err), true
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return fmt.Sprintf("connection refused (%s); check proxy and firewall settings", err), true
+	case errors.As(err, &certErr):
+		return fmt.Sprintf("TLS certificate rejected (%s); check -ca-cert or a TLS-inspecting proxy", certErr.Err), true
+	case errors.As(err, &authorityErr), errors.As(err, &hostnameErr):
+		return fmt.Sprintf("TLS certificate rejected (%s); check -ca-cert or a TLS-inspecting proxy", err), true
+	case errors.As(err, &recordErr), errors.As(err, &alertErr):
+		return fmt.Sprintf("TLS handshake failed (%s)", err), true
+	case errors.As(err, &opErr):
+		return fmt.Sprintf("network error: %s", opErr), true
+	}
+	return "", false
+}
+
+// responseErrorDetails extracts error.message and error.innererror.code from
+// a Key Vault error body.
+func responseErrorDetails(respErr *azcore.ResponseError) (message, innerCode string) {
+	if respErr.RawResponse == nil {
+		return "", ""
+	}
+	body, err := runtime.Payload(respErr.RawResponse)
+	if err != nil {
+		return "", ""
+	}
+	var payload struct {
+		Error struct {
+			Message    string `json:"message"`
+			InnerError struct {
+				Code string `json:"code"`
+			} `json:"innererror"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return "", ""
+	}
+	return payload.Error.Message, payload.Error.InnerError.Code
+}
+
+// label renders the category with its status code, e.g. "Forbidden (403)".
+func (c classifiedError) label() string {
+	if c.statusCode == 0 {
+		return c.category
+	}
+	return fmt.Sprintf("%s (%d)", c.category, c.statusCode)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"syscall"
+	"testing"
+)
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		category   string
+		statusCode int
+		errorCode  string
+		message    string
+		authModel  string
+	}{
+		{
+			name:       "forbidden by RBAC",
+			err:        fmt.Errorf("sign operation failed: %w", forbidden()),
+			category:   categoryForbidden,
+			statusCode: http.StatusForbidden,
+			errorCode:  "Forbidden",
+			message:    "Caller is not authorized to perform action on resource.",
+			authModel:  authModelRBAC,
+		},
+		{
+			name:       "forbidden by access policy",
+			err:        responseError(http.StatusForbidden, "Forbidden", "AccessDenied", "The user does not have keys sign permission on key vault."),
+			category:   categoryForbidden,
+			statusCode: http.StatusForbidden,
+			errorCode:  "Forbidden",
+			message:    "The user does not have keys sign permission on key vault.",
+			authModel:  authModelAccessPolicy,
+		},
+		{
+			name:       "forbidden by firewall",
+			err:        responseError(http.StatusForbidden, "Forbidden", "ForbiddenByFirewall", "Client address is not authorized and caller is not a trusted service."),
+			category:   categoryForbidden,
+			statusCode: http.StatusForbidden,
+			errorCode:  "Forbidden",
+			message:    "Client address is not authorized and caller is not a trusted service.",
+			authModel:  authModelFirewall,
+		},
+		{
+			name:       "unauthorized",
+			err:        responseError(http.StatusUnauthorized, "Unauthorized", "", "AKV10032: Invalid issuer."),
+			category:   categoryUnauthorized,
+			statusCode: http.StatusUnauthorized,
+			errorCode:  "Unauthorized",
+			message:    "AKV10032: Invalid issuer.",
+		},
+		{
+			name:       "not found",
+			err:        responseError(http.StatusNotFound, "KeyNotFound", "", "A key with (name/id) fake-key was not found in this key vault."),
+			category:   categoryNotFound,
+			statusCode: http.StatusNotFound,
+			errorCode:  "KeyNotFound",
+			message:    "A key with (name/id) fake-key was not found in this key vault.",
+		},
+		{
+			name:       "throttled",
+			err:        responseError(http.StatusTooManyRequests, "Throttled", "", "Request was not processed because too many requests were received."),
+			category:   categoryThrottled,
+			statusCode: http.StatusTooManyRequests,
+			errorCode:  "Throttled",
+			message:    "Request was not processed because too many requests were received.",
+		},
+		{
+			name:       "other status",
+			err:        responseError(http.StatusBadRequest, "BadParameter", "", "Invalid algorithm."),
+			category:   categoryOther,
+			statusCode: http.StatusBadRequest,
+			errorCode:  "BadParameter",
+			message:    "Invalid algorithm.",
+		},
+		{
+			name:     "timeout",
+			err:      fmt.Errorf("sign operation failed: %w", context.DeadlineExceeded),
+			category: categoryTimeout,
+			message:  "sign operation failed: context deadline exceeded",
+		},
+		{
+			name:     "connection refused",
+			err:      fmt.Errorf("sign operation failed: %w", syscall.ECONNREFUSED),
+			category: categoryNetwork,
+			message:  "connection refused (sign operation failed: connection refused); check proxy and firewall settings",
+		},
+		{
+			name:     "no response",
+			err:      errors.New("something else"),
+			category: categoryOther,
+			message:  "something else",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := classifyError(tt.err)
+			if c.category != tt.category {
+				t.Errorf("category = %q, want %q", c.category, tt.category)
+			}
+			if c.statusCode != tt.statusCode {
+				t.Errorf("statusCode = %d, want %d", c.statusCode, tt.statusCode)
+			}
+			if c.errorCode != tt.errorCode {
+				t.Errorf("errorCode = %q, want %q", c.errorCode, tt.errorCode)
+			}
+			if c.message != tt.message {
+				t.Errorf("message = %q, want %q", c.message, tt.message)
+			}
+			if c.authModel != tt.authModel {
+				t.Errorf("authModel = %q, want %q", c.authModel, tt.authModel)
+			}
+		})
+	}
+}
+
+func TestClassifyErrorRequestIDs(t *testing.T) {
+	c := classifyError(forbidden())
+	if c.requestID != "fake-request-id" {
+		t.Errorf("requestID = %q, want %q", c.requestID, "fake-request-id")
+	}
+	if c.clientRequestID != "fake-client-request-id" {
+		t.Errorf("clientRequestID = %q, want %q", c.clientRequestID, "fake-client-request-id")
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// placeholderObjectID stands in for the caller's object ID in -suggest-fix
+// commands when it could not be read from the access token.
+const placeholderObjectID = "<object-id>"
+
+// hsmRoles maps Key Vault roles to the Managed HSM local role that grants
+// the same operations, where the name is not simply "Managed HSM" in place
+// of "Key Vault".
+var hsmRoles = map[string]string{
+	"Key Vault Reader": "Managed HSM Crypto Auditor",
+}
+
+// fixReporter prints, once the run is finished, the Azure CLI commands that
+// grant the permissions Key Vault denied, for -suggest-fix. Each vault gets
+// one command per missing RBAC role, or one set-policy command per plane
+// for access policy vaults. When the vault's authorization model is not
+// known, both forms are printed.
+type fixReporter struct {
+	w io.Writer
+
+	// objectID and principalType identify the caller, from the access
+	// token. principalType is empty when it is not known.
+	objectID      string
+	principalType string
+}
+
+func (f *fixReporter) beginKey(r *runReport) {}
+
+func (f *fixReporter) result(res testResult) {}
+
+func (f *fixReporter) endKey(r *runReport) {}
+
+func (f *fixReporter) finish(reports []*runReport) error {
+	var vaults []string
+	denied := make(map[string][]testResult)
+	hsm := make(map[string]bool)
+	for _, r := range reports {
+		for _, res := range r.Results {
+			if !isFailure(res) || res.ErrorCategory != categoryForbidden {
+				continue
+			}
+			if _, ok := operationPermissions[res.Operation]; !ok && res.AuthorizationModel != authModelFirewall {
+				continue
+			}
+			if _, ok := denied[r.VaultURL]; !ok {
+				vaults = append(vaults, r.VaultURL)
+			}
+			denied[r.VaultURL] = append(denied[r.VaultURL], res)
+			hsm[r.VaultURL] = r.ManagedHSM
+		}
+	}
+	if len(vaults) == 0 {
+		return nil
+	}
+
+	fmt.Fprintln(f.w, "\nSuggested fixes:")
+	for _, vault := range vaults {
+		fmt.Fprintln(f.w)
+		f.printVault(vault, hsm[vault], denied[vault])
+	}
+	if f.objectID == placeholderObjectID {
+		fmt.Fprintf(f.w, "\nReplace %s with the identity's object ID; it could not be read from the access token.\n", placeholderObjectID)
+	}
+	return nil
+}
+
+// printVault prints the commands that grant the operations denied on vault.
+func (f *fixReporter) printVault(vault string, managedHSM bool, denied []testResult) {
+	name := vaultName(vault)
+	fmt.Fprintf(f.w, "# %s\n", vault)
+
+	var roles []string
+	permissions := make(map[string][]string)
+	var planes []string
+	firewall, unknown := false, false
+	for _, res := range denied {
+		switch res.AuthorizationModel {
+		case authModelFirewall:
+			firewall = true
+			continue
+		case "":
+			unknown = true
+		}
+		perm := operationPermissions[res.Operation]
+		if res.AuthorizationModel != authModelAccessPolicy && !slices.Contains(roles, perm.role) {
+			roles = append(roles, perm.role)
+		}
+		if res.AuthorizationModel != authModelRBAC && !managedHSM {
+			if _, ok := permissions[perm.plane]; !ok {
+				planes = append(planes, perm.plane)
+			}
+			if !slices.Contains(permissions[perm.plane], perm.permission) {
+				permissions[perm.plane] = append(permissions[perm.plane], perm.permission)
+			}
+		}
+	}
+
+	if firewall {
+		fmt.Fprintln(f.w, "# The vault firewall rejected some requests; allow this client's network:")
+		fmt.Fprintf(f.w, "az keyvault network-rule add --name %s --ip-address <client-ip>\n", name)
+	}
+	if unknown && len(roles) > 0 && len(planes) > 0 {
+		fmt.Fprintln(f.w, "# The authorization model could not be detected; run the commands for the model the vault uses.")
+	}
+	if len(roles) > 0 {
+		if unknown && !managedHSM {
+			fmt.Fprintln(f.w, "# If the vault uses Azure RBAC:")
+		}
+		for _, role := range roles {
+			fmt.Fprintln(f.w, f.roleAssignment(name, role, managedHSM))
+		}
+	}
+	if len(planes) > 0 {
+		if unknown {
+			fmt.Fprintln(f.w, "# If the vault uses access policies:")
+		}
+		cmd := fmt.Sprintf("az keyvault set-policy --name %s --object-id %s", name, f.objectID)
+		for _, plane := range planes {
+			cmd += fmt.Sprintf(" --%s-permissions %s", plane, strings.Join(permissions[plane], " "))
+		}
+		fmt.Fprintln(f.w, cmd)
+	}
+}
+
+// roleAssignment returns the command that assigns role to the caller on the
+// vault. Managed HSMs use their own local roles, assigned to all keys.
+func (f *fixReporter) roleAssignment(name, role string, managedHSM bool) string {
+	var principalType string
+	if f.principalType != "" {
+		principalType = " --assignee-principal-type " + f.principalType
+	}
+	if managedHSM {
+		hsmRole, ok := hsmRoles[role]
+		if !ok {
+			hsmRole = strings.Replace(role, "Key Vault ", "Managed HSM ", 1)
+		}
+		return fmt.Sprintf("az keyvault role assignment create --hsm-name %s --role %q --assignee-object-id %s%s --scope /keys", name, hsmRole, f.objectID, principalType)
+	}
+	return fmt.Sprintf("az role assignment create --role %q --assignee-object-id %s%s --scope \"$(az keyvault show --name %s --query id -o tsv)\"", role, f.objectID, principalType, name)
+}
+
+// vaultName returns the vault or Managed HSM name of a vault URL, the first
+// label of its host.
+func vaultName(vaultURL string) string {
+	u, err := url.Parse(vaultURL)
+	if err != nil {
+		return vaultURL
+	}
+	name, _, _ := strings.Cut(u.Hostname(), ".")
+	return name
+}
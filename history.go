@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// historyReporter appends one JSON object describing the run to a
+// newline-delimited history file for -append-history, so that scheduled runs
+// build a record of permission drift. Earlier lines are never rewritten.
+type historyReporter struct {
+	path string
+}
+
+// historyEntry is one line of the history file.
+type historyEntry struct {
+	Timestamp time.Time       `json:"timestamp"`
+	Summary   *runSummary     `json:"summary"`
+	Results   []historyResult `json:"results"`
+}
+
+// historyResult is the outcome of one test in a history entry, keyed like the
+// Prometheus metrics so that the same test can be followed from run to run.
+type historyResult struct {
+	Vault         string `json:"vault"`
+	Key           string `json:"key"`
+	Operation     string `json:"operation"`
+	Algorithm     string `json:"algorithm,omitempty"`
+	Status        string `json:"status"`
+	ErrorCategory string `json:"errorCategory,omitempty"`
+}
+
+func (h *historyReporter) beginKey(r *runReport) {}
+
+func (h *historyReporter) result(res testResult) {}
+
+func (h *historyReporter) endKey(r *runReport) {}
+
+func (h *historyReporter) finish(reports []*runReport) error {
+	entry := historyEntry{
+		Timestamp: time.Now().UTC(),
+		Summary:   summarize(reports),
+		Results:   []historyResult{},
+	}
+	for _, r := range reports {
+		for _, res := range r.Results {
+			entry.Results = append(entry.Results, historyResult{
+				Vault:         r.VaultURL,
+				Key:           summaryRowName(r),
+				Operation:     res.Operation,
+				Algorithm:     res.Algorithm,
+				Status:        resultStatus(res),
+				ErrorCategory: res.ErrorCategory,
+			})
+		}
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to encode history entry: %w", err)
+	}
+	// Write the line with a single call, so concurrent runs appending to the
+	// same file do not interleave their entries
+	f, err := os.OpenFile(h.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open history file: %w", err)
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to append to history file: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to append to history file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// resultStreamer is implemented by reporters that write each result the
+// moment it is recorded. Results otherwise reach reporters per key, and with
+// -concurrency only once every key is done; stream is called as soon as each
+// test completes, one call at a time.
+type resultStreamer interface {
+	stream(r *runReport, res testResult)
+}
+
+// jsonlReporter writes JSON Lines for -output jsonl: one object per result,
+// written with a single call as the test completes so that consumers can
+// process a long sweep as it runs, and a final line with the run summary.
+type jsonlReporter struct {
+	w io.Writer
+
+	// failuresOnly drops passed and skipped results, for -quiet.
+	failuresOnly bool
+
+	// err is the first write error, reported by finish.
+	err error
+}
+
+// jsonlResult is one result line: the result's fields, labelled with the
+// vault and the key, secret, or certificate of its report.
+type jsonlResult struct {
+	VaultURL        string `json:"vaultUrl"`
+	KeyName         string `json:"keyName,omitempty"`
+	SecretName      string `json:"secretName,omitempty"`
+	CertificateName string `json:"certificateName,omitempty"`
+	testResult
+}
+
+func (j *jsonlReporter) beginKey(r *runReport) {}
+
+func (j *jsonlReporter) result(res testResult) {}
+
+func (j *jsonlReporter) endKey(r *runReport) {}
+
+func (j *jsonlReporter) stream(r *runReport, res testResult) {
+	if j.failuresOnly && !isFailure(res) {
+		return
+	}
+	j.writeLine(jsonlResult{
+		VaultURL:        r.VaultURL,
+		KeyName:         r.KeyName,
+		SecretName:      r.SecretName,
+		CertificateName: r.CertificateName,
+		testResult:      res,
+	})
+}
+
+// finish writes the summary line, which counts every result, even with
+// -quiet.
+func (j *jsonlReporter) finish(reports []*runReport) error {
+	j.writeLine(struct {
+		Summary *runSummary `json:"summary"`
+	}{summarize(reports)})
+	return j.err
+}
+
+func (j *jsonlReporter) writeLine(v any) {
+	if j.err != nil {
+		return
+	}
+	line, err := json.Marshal(v)
+	if err != nil {
+		j.err = fmt.Errorf("failed to encode JSON line: %w", err)
+		return
+	}
+	_, j.err = j.w.Write(append(line, '\n'))
+}
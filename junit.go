@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// JUnit XML elements, following the schema consumed by Azure DevOps,
+// Jenkins, and GitLab test report viewers.
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Skipped  int              `xml:"skipped,attr"`
+	Time     string           `xml:"time,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Skipped  int             `xml:"skipped,attr"`
+	Time     string          `xml:"time,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Skipped   *junitSkipped `xml:"skipped,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr,omitempty"`
+	Text    string `xml:",chardata"`
+}
+
+type junitSkipped struct {
+	Message string `xml:"message,attr,omitempty"`
+}
+
+// junitReporter emits the whole run as a JUnit XML document once it
+// completes. Each tested key becomes a test suite and each permission test a
+// test case, with the key name as the classname.
+type junitReporter struct {
+	w io.Writer
+}
+
+func (j *junitReporter) beginKey(r *runReport) {}
+
+func (j *junitReporter) result(res testResult) {}
+
+func (j *junitReporter) endKey(r *runReport) {}
+
+func (j *junitReporter) finish(reports []*runReport) error {
+	doc := junitTestSuites{Name: "azkeyvault-perm-tester"}
+	var total time.Duration
+
+	for _, r := range reports {
+		suite := junitTestSuite{Name: junitSuiteName(r)}
+		classname := junitClassname(r)
+		var elapsed time.Duration
+
+		for _, res := range r.Results {
+			name := res.Operation
+			if res.Algorithm != "" {
+				name += " " + res.Algorithm
+			}
+			tc := junitTestCase{
+				Name:      name,
+				Classname: classname,
+				Time:      junitSeconds(res.Duration),
+			}
+			switch {
+			case res.Skipped:
+				tc.Skipped = &junitSkipped{Message: strings.Join(res.Notes, "; ")}
+				suite.Skipped++
+			case !res.Success:
+				tc.Failure = &junitFailure{
+					Message: *res.Error,
+					Type:    res.ErrorCategory,
+					Text:    fmt.Sprintf("%s failed%s: %s", operationLabels[res.Operation], failureTag(res), *res.Error),
+				}
+				if res.Remediation != "" {
+					tc.Failure.Text += "\n" + res.Remediation
+				}
+				if id := requestIDs(res); id != "" {
+					tc.Failure.Text += "\n" + id
+				}
+				suite.Failures++
+			}
+			suite.Tests++
+			elapsed += res.Duration
+			suite.Cases = append(suite.Cases, tc)
+		}
+
+		suite.Time = junitSeconds(elapsed)
+		doc.Tests += suite.Tests
+		doc.Failures += suite.Failures
+		doc.Skipped += suite.Skipped
+		total += elapsed
+		doc.Suites = append(doc.Suites, suite)
+	}
+	doc.Time = junitSeconds(total)
+
+	if _, err := io.WriteString(j.w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(j.w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(j.w, "\n")
+	return err
+}
+
+// junitSuiteName describes what a report tested, e.g. "myvault.vault.azure.net/my-key".
+func junitSuiteName(r *runReport) string {
+	host := junitVaultHost(r.VaultURL)
+	switch {
+	case r.KeyName != "":
+		return host + "/" + r.KeyName
+	case r.SecretName != "":
+		return host + "/secrets/" + r.SecretName
+	case r.CertificateName != "":
+		return host + "/certificates/" + r.CertificateName
+	}
+	return host
+}
+
+// junitClassname is the key name for per-key tests. Vault-wide, secret, and
+// certificate tests, which have no key, use the vault host or the secret or
+// certificate name instead.
+func junitClassname(r *runReport) string {
+	switch {
+	case r.KeyName != "":
+		return r.KeyName
+	case r.SecretName != "":
+		return "secret:" + r.SecretName
+	case r.CertificateName != "":
+		return "certificate:" + r.CertificateName
+	}
+	return junitVaultHost(r.VaultURL)
+}
+
+func junitVaultHost(vaultURL string) string {
+	if u, err := url.Parse(vaultURL); err == nil && u.Host != "" {
+		return u.Host
+	}
+	return vaultURL
+}
+
+// junitSeconds formats a duration as JUnit's fractional seconds.
+func junitSeconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
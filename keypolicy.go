@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azkeys"
+)
+
+// keyPolicy is the minimum strength required of each tested key, from
+// -min-rsa-bits and -allowed-curves. The zero value requires nothing.
+type keyPolicy struct {
+	minRSABits    int
+	allowedCurves []string
+}
+
+// parseKeyPolicy validates the -min-rsa-bits and -allowed-curves values.
+func parseKeyPolicy(minRSABits int, allowedCurves string) (keyPolicy, error) {
+	if minRSABits < 0 {
+		return keyPolicy{}, fmt.Errorf("-min-rsa-bits must not be negative")
+	}
+	p := keyPolicy{minRSABits: minRSABits}
+	for _, curve := range splitList(allowedCurves) {
+		known := slices.ContainsFunc(azkeys.PossibleCurveNameValues(), func(c azkeys.CurveName) bool {
+			return strings.EqualFold(string(c), curve)
+		})
+		if !known {
+			return keyPolicy{}, fmt.Errorf("-allowed-curves: unknown curve %q (expected P-256, P-256K, P-384, or P-521)", curve)
+		}
+		p.allowedCurves = append(p.allowedCurves, strings.ToUpper(curve))
+	}
+	return p, nil
+}
+
+// enabled reports whether the policy requires anything of a key.
+func (p keyPolicy) enabled() bool {
+	return p.minRSABits > 0 || len(p.allowedCurves) > 0
+}
+
+// check compares a key read by GET against the policy. It returns why the
+// key is too weak, or "" when it complies, and a note when no requirement of
+// the policy applies to the key's type.
+func (p keyPolicy) check(key *keyInfo) (violation, note string) {
+	switch {
+	case isRSAKeyType(key.keyType) && p.minRSABits > 0:
+		if key.rsaBits < p.minRSABits {
+			return fmt.Sprintf("%s is %d bits, below the required %d (-min-rsa-bits)", keyDescription(key.keyType, ""), key.rsaBits, p.minRSABits), ""
+		}
+	case isECKeyType(key.keyType) && len(p.allowedCurves) > 0:
+		if !slices.Contains(p.allowedCurves, strings.ToUpper(key.curve)) {
+			return fmt.Sprintf("%s is not one of the allowed curves %s (-allowed-curves)", keyDescription(key.keyType, key.curve), strings.Join(p.allowedCurves, ", ")), ""
+		}
+	default:
+		return "", fmt.Sprintf("No key policy requirement applies to a %s", keyDescription(key.keyType, key.curve))
+	}
+	return "", ""
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"time"
+)
+
+// repeatableOperations are the operations -repeat may run more than once.
+// Operations that change the vault, such as create or set secret, always run
+// once so that a repeat never leaves extra keys or secret versions behind.
+var repeatableOperations = map[string]bool{
+	opSign:        true,
+	opVerify:      true,
+	opLocalVerify: true,
+	opGet:         true,
+	opEncrypt:     true,
+	opDecrypt:     true,
+	opWrapKey:     true,
+	opUnwrapKey:   true,
+	opList:        true,
+	opSecretGet:   true,
+	opCertGet:     true,
+	opBackup:      true,
+
+	opRotationPolicyGet: true,
+	opGetDeleted:        true,
+	opRandomBytes:       true,
+}
+
+// latencyStats summarizes the latency of an operation that -repeat or
+// -benchmark ran several times. The percentiles and throughput are only set
+// by -benchmark.
+type latencyStats struct {
+	Runs   int     `json:"runs"`
+	Warmup int     `json:"warmup,omitempty"`
+	MinMs  float64 `json:"minMs"`
+	AvgMs  float64 `json:"avgMs"`
+	MaxMs  float64 `json:"maxMs"`
+
+	P50Ms     float64 `json:"p50Ms,omitempty"`
+	P95Ms     float64 `json:"p95Ms,omitempty"`
+	P99Ms     float64 `json:"p99Ms,omitempty"`
+	OpsPerSec float64 `json:"opsPerSec,omitempty"`
+}
+
+// newLatencyStats summarizes the durations of successive runs, with
+// percentiles and throughput when percentiles is set.
+func newLatencyStats(durations []time.Duration, percentiles bool) *latencyStats {
+	sorted := slices.Clone(durations)
+	slices.Sort(sorted)
+	var total time.Duration
+	for _, d := range sorted {
+		total += d
+	}
+
+	stats := &latencyStats{
+		Runs:  len(sorted),
+		MinMs: milliseconds(sorted[0]),
+		AvgMs: milliseconds(total / time.Duration(len(sorted))),
+		MaxMs: milliseconds(sorted[len(sorted)-1]),
+	}
+	if percentiles {
+		stats.P50Ms = milliseconds(percentile(sorted, 50))
+		stats.P95Ms = milliseconds(percentile(sorted, 95))
+		stats.P99Ms = milliseconds(percentile(sorted, 99))
+		if total > 0 {
+			// Runs are sequential, so this is the throughput of one caller
+			stats.OpsPerSec = float64(len(sorted)) / total.Seconds()
+		}
+	}
+	return stats
+}
+
+// percentile returns the nearest-rank pth percentile of sorted durations.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	rank := (p*len(sorted) + 99) / 100
+	return sorted[max(rank, 1)-1]
+}
+
+// milliseconds converts d to fractional milliseconds, rounded to the
+// microsecond.
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// latencyTag describes how long a result took for text output, e.g.
+// " (84 ms)" or, with -repeat, " (avg 84 ms, min 80 ms, max 91 ms over 5 runs)".
+// Results that made no Key Vault call have no tag.
+func latencyTag(res testResult) string {
+	if res.Latency != nil && res.Latency.Runs > 1 {
+		return fmt.Sprintf(" (avg %.0f ms, min %.0f ms, max %.0f ms over %d runs)", res.Latency.AvgMs, res.Latency.MinMs, res.Latency.MaxMs, res.Latency.Runs)
+	}
+	if res.Duration == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" (%.0f ms)", res.DurationMs)
+}
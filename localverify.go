@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azkeys"
+
+	"azkeyvault-perm-tester/pkg/permtest"
+)
+
+// unsupportedCurveError is an EC key on a curve the standard library does
+// not implement, such as P-256K (secp256k1). Such a key works in Key Vault,
+// but cannot be used locally.
+type unsupportedCurveError struct {
+	curve azkeys.CurveName
+}
+
+func (e *unsupportedCurveError) Error() string {
+	return fmt.Sprintf("curve %s is not supported locally", e.curve)
+}
+
+// publicKeyFromJWK reconstructs an RSA, EC, or Ed25519 public key from a Key
+// Vault JWK.
+func publicKeyFromJWK(key *azkeys.JSONWebKey) (crypto.PublicKey, error) {
+	if key == nil || key.Kty == nil {
+		return nil, errors.New("key has no key type")
+	}
+
+	switch *key.Kty {
+	case azkeys.KeyTypeRSA, azkeys.KeyTypeRSAHSM:
+		if len(key.N) == 0 || len(key.E) == 0 {
+			return nil, errors.New("RSA key is missing its modulus or exponent")
+		}
+		e := new(big.Int).SetBytes(key.E)
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, errors.New("RSA public exponent is too large")
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(key.N),
+			E: int(e.Int64()),
+		}, nil
+
+	case azkeys.KeyTypeEC, azkeys.KeyTypeECHSM:
+		if key.Crv == nil {
+			return nil, errors.New("EC key has no curve")
+		}
+		var curve elliptic.Curve
+		switch *key.Crv {
+		case azkeys.CurveNameP256:
+			curve = elliptic.P256()
+		case azkeys.CurveNameP384:
+			curve = elliptic.P384()
+		case azkeys.CurveNameP521:
+			curve = elliptic.P521()
+		default:
+			return nil, &unsupportedCurveError{curve: *key.Crv}
+		}
+		return &ecdsa.PublicKey{
+			Curve: curve,
+			X:     new(big.Int).SetBytes(key.X),
+			Y:     new(big.Int).SetBytes(key.Y),
+		}, nil
+
+	case permtest.KeyTypeOKP, permtest.KeyTypeOKPHSM:
+		if key.Crv == nil || *key.Crv != permtest.CurveNameEd25519 {
+			return nil, errors.New("OKP key is not on curve Ed25519")
+		}
+		if len(key.X) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("Ed25519 public key is %d bytes, expected %d", len(key.X), ed25519.PublicKeySize)
+		}
+		return ed25519.PublicKey(key.X), nil
+	}
+
+	return nil, fmt.Errorf("key type %s is not supported for local verification", *key.Kty)
+}
+
+// verifyLocally checks a Key Vault signature over digest using Go's crypto
+// packages instead of the Verify API.
+func verifyLocally(pub crypto.PublicKey, algorithm azkeys.SignatureAlgorithm, digest []byte, signature []byte) error {
+	hash, ok := permtest.HashForAlgorithm(algorithm)
+	if !ok {
+		return fmt.Errorf("algorithm %s is not supported for local verification", algorithm)
+	}
+
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		switch algorithm {
+		case azkeys.SignatureAlgorithmRS256, azkeys.SignatureAlgorithmRS384, azkeys.SignatureAlgorithmRS512:
+			return rsa.VerifyPKCS1v15(pub, hash, digest, signature)
+		case azkeys.SignatureAlgorithmPS256, azkeys.SignatureAlgorithmPS384, azkeys.SignatureAlgorithmPS512:
+			return rsa.VerifyPSS(pub, hash, digest, signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		}
+		return fmt.Errorf("algorithm %s cannot be used with an RSA key", algorithm)
+
+	case *ecdsa.PublicKey:
+		// Key Vault returns EC signatures as the raw concatenation r || s
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return fmt.Errorf("EC signature is %d bytes, expected %d for curve %s", len(signature), 2*size, pub.Curve.Params().Name)
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(pub, digest, r, s) {
+			return errors.New("ecdsa: verification error")
+		}
+		return nil
+
+	case ed25519.PublicKey:
+		if algorithm != permtest.SignatureAlgorithmEdDSA {
+			return fmt.Errorf("algorithm %s cannot be used with an Ed25519 key", algorithm)
+		}
+		// EdDSA signs the digest itself, as the message
+		if !ed25519.Verify(pub, digest, signature) {
+			return errors.New("ed25519: verification error")
+		}
+		return nil
+	}
+
+	return fmt.Errorf("unsupported public key type %T", pub)
+}
+
+// doTestLocalVerify fetches the public key within ctx, which carries the
+// -timeout deadline like any other operation, and verifies signature with it.
+// An expired or cancelled context is returned wrapped, so that it is reported
+// as a timeout or an interruption rather than as a bad signature.
+func doTestLocalVerify(ctx context.Context, client keyVaultClient, keyName string, keyVersion string, digest []byte, signature []byte, algorithm azkeys.SignatureAlgorithm) error {
+	resp, err := client.GetKey(ctx, keyName, keyVersion, nil)
+	if err != nil {
+		return fmt.Errorf("get key operation failed: %w", err)
+	}
+
+	// The fetch can return just as the deadline passes; don't spend CPU on
+	// a verification whose result would be discarded
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("local verify operation stopped before verifying: %w", err)
+	}
+
+	pub, err := publicKeyFromJWK(resp.Key)
+	if err != nil {
+		return fmt.Errorf("failed to reconstruct public key: %w", err)
+	}
+
+	if err := verifyLocally(pub, algorithm, digest, signature); err != nil {
+		return fmt.Errorf("local signature verification failed: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// newLogger returns the diagnostic logger selected by -log-level and
+// -log-format. Test results never go through it; they are written to stdout
+// by the reporter so that diagnostics on w can be collected separately.
+func newLogger(w io.Writer, level, format string) (*slog.Logger, error) {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("unknown log level %q (expected debug, info, warn, or error)", level)
+	}
+	opts := &slog.HandlerOptions{Level: l}
+
+	switch strings.ToLower(format) {
+	case "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("unknown log format %q (expected text or json)", format)
+	}
+}
+
+// fatal logs msg at error level and exits with status 1.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
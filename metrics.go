@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// metricsReporter writes the results of the run to a file in the Prometheus
+// text exposition format once it completes, for the node_exporter textfile
+// collector. Passed tests are reported as 1 and failed tests as 0; skipped
+// tests were not attempted and are left out.
+type metricsReporter struct {
+	path string
+}
+
+func (m *metricsReporter) beginKey(r *runReport) {}
+
+func (m *metricsReporter) result(res testResult) {}
+
+func (m *metricsReporter) endKey(r *runReport) {}
+
+func (m *metricsReporter) finish(reports []*runReport) error {
+	var buf bytes.Buffer
+	fmt.Fprintln(&buf, "# HELP azkv_permission_test Whether a Key Vault permission test passed (1) or failed (0).")
+	fmt.Fprintln(&buf, "# TYPE azkv_permission_test gauge")
+	for _, r := range reports {
+		for _, res := range r.Results {
+			if res.Skipped {
+				continue
+			}
+			value := 0
+			if res.Success {
+				value = 1
+			}
+			fmt.Fprintf(&buf, "azkv_permission_test%s %d\n", metricLabels(r, res), value)
+		}
+	}
+
+	fmt.Fprintln(&buf, "# HELP azkv_permission_test_duration_seconds How long the Key Vault call of a permission test took.")
+	fmt.Fprintln(&buf, "# TYPE azkv_permission_test_duration_seconds gauge")
+	for _, r := range reports {
+		for _, res := range r.Results {
+			if res.Skipped || res.Duration == 0 {
+				continue
+			}
+			fmt.Fprintf(&buf, "azkv_permission_test_duration_seconds%s %s\n", metricLabels(r, res), strconv.FormatFloat(res.Duration.Seconds(), 'f', -1, 64))
+		}
+	}
+
+	fmt.Fprintln(&buf, "# HELP azkv_permission_test_last_run_timestamp_seconds When the permission tests last ran.")
+	fmt.Fprintln(&buf, "# TYPE azkv_permission_test_last_run_timestamp_seconds gauge")
+	fmt.Fprintf(&buf, "azkv_permission_test_last_run_timestamp_seconds %d\n", time.Now().Unix())
+
+	// The collector may read the file at any time, so replace it atomically
+	tmp := m.path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0o644); err != nil {
+		return fmt.Errorf("failed to write metrics file: %w", err)
+	}
+	if err := os.Rename(tmp, m.path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write metrics file: %w", err)
+	}
+	return nil
+}
+
+// metricLabels renders the label set identifying a result, e.g.
+// {vault="https://myvault.vault.azure.net/",key="my-key",operation="sign"}.
+// The key label holds the summary row name, so vault-wide, secret, and
+// certificate tests are labelled too.
+func metricLabels(r *runReport, res testResult) string {
+	labels := []string{
+		"vault=" + metricLabelValue(r.VaultURL),
+		"key=" + metricLabelValue(summaryRowName(r)),
+		"operation=" + metricLabelValue(res.Operation),
+	}
+	if res.Algorithm != "" {
+		labels = append(labels, "algorithm="+metricLabelValue(res.Algorithm))
+	}
+	return "{" + strings.Join(labels, ",") + "}"
+}
+
+// metricLabelValue quotes a label value, escaping backslashes, quotes, and
+// newlines as the exposition format requires.
+func metricLabelValue(v string) string {
+	v = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+	return `"` + v + `"`
+}
@@ -0,0 +1,554 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azkeys"
+)
+
+// temporaryKeyPrefix names the keys created by the create and import tests,
+// so any left behind by an interrupted run are easy to identify.
+const temporaryKeyPrefix = "azkeyvault-perm-tester-"
+
+// createKeySpec describes the temporary key made by the create test, and the
+// key generated locally by the import test.
+type createKeySpec struct {
+	keyType azkeys.KeyType
+	size    int32
+	curve   azkeys.CurveName
+}
+
+// ecCurvesBySize maps -create-key-size values to curves for EC keys.
+var ecCurvesBySize = map[int]azkeys.CurveName{
+	256: azkeys.CurveNameP256,
+	384: azkeys.CurveNameP384,
+	521: azkeys.CurveNameP521,
+}
+
+// parseCreateKeySpec validates -create-key-type and -create-key-size. A size
+// of zero selects RSA 2048 or EC P-256.
+func parseCreateKeySpec(keyType string, size int) (createKeySpec, error) {
+	spec := createKeySpec{keyType: azkeys.KeyType(strings.ToUpper(keyType))}
+	switch {
+	case isRSAKeyType(string(spec.keyType)):
+		if size == 0 {
+			size = 2048
+		}
+		if size != 2048 && size != 3072 && size != 4096 {
+			return spec, fmt.Errorf("RSA key size %d is not supported (expected 2048, 3072, or 4096)", size)
+		}
+		spec.size = int32(size)
+	case isECKeyType(string(spec.keyType)):
+		if size == 0 {
+			size = 256
+		}
+		curve, ok := ecCurvesBySize[size]
+		if !ok {
+			return spec, fmt.Errorf("EC key size %d is not supported (expected 256, 384, or 521)", size)
+		}
+		spec.curve = curve
+	default:
+		return spec, fmt.Errorf("key type %q cannot be created (expected RSA, RSA-HSM, EC, or EC-HSM)", keyType)
+	}
+	return spec, nil
+}
+
+// runKeyLifecycleTests creates a temporary key, sets its rotation policy and
+// rotates it, updates its tags, deletes it again, reads and recovers the
+// deleted key, purges it, and imports a locally generated key, passing each
+// result to record as it completes. A key that was created or imported is
+// always deleted before returning, even if the delete test was not selected
+// or the run was stopped early. It returns false as soon as record returns
+// false.
+func runKeyLifecycleTests(ctx context.Context, client keyVaultClient, cfg testConfig, record func(testResult) bool) bool {
+	// planned is the name a -dry-run create would have used, so that the
+	// delete and purge tests can show their requests too. deleted is the
+	// key the delete test deleted, or would have deleted.
+	var created, createdID, planned, deleted, imported string
+
+	// redeleteErr is why the key the recover test recovered could not be
+	// deleted again for the purge test
+	var redeleteErr error
+	defer func() {
+		// Clean up even if the run was interrupted
+		for _, name := range []string{created, imported} {
+			if name != "" {
+				cleanupTemporaryKey(context.WithoutCancel(ctx), client, cfg, name)
+			}
+		}
+	}()
+
+	if cfg.create {
+		res := testResult{Operation: opCreate}
+		name, err := temporaryKeyName()
+		if err == nil {
+			var info *keyInfo
+			err = cfg.timed(ctx, &res, func(ctx context.Context) (err error) {
+				info, err = doTestCreateKey(ctx, client, name, cfg.createKey)
+				return err
+			})
+			if err == nil {
+				created = name
+				createdID = info.keyID
+				res.KeyID = info.keyID
+				res.KeyType = info.keyType
+				res.Curve = info.curve
+			} else if isDryRun(err) {
+				planned = name
+			}
+		}
+		if err != nil {
+			res.fail(err)
+		} else {
+			res.Success = true
+		}
+		if !record(res) {
+			return false
+		}
+	}
+
+	temporary := created
+	if temporary == "" {
+		temporary = planned
+	}
+	if !runRotationTests(ctx, client, temporary, createdID, cfg, record) {
+		return false
+	}
+
+	if cfg.update {
+		res := testResult{Operation: opUpdate}
+		if temporary == "" {
+			res.skip("Update testing requires a key created in this run; add -test-create")
+		} else {
+			err := cfg.timed(ctx, &res, func(ctx context.Context) error {
+				return doTestUpdateKey(ctx, client, temporary)
+			})
+			if err != nil {
+				res.fail(err)
+			} else {
+				res.Success = true
+				res.Notes = append(res.Notes, fmt.Sprintf("The %s tag was added to %s and removed again", updateTestTag, temporary))
+			}
+		}
+		if !record(res) {
+			return false
+		}
+	}
+
+	if cfg.delete {
+		res := testResult{Operation: opDelete}
+		name := created
+		if name == "" {
+			name = planned
+		}
+		if name == "" {
+			res.skip("No key available from create test, skipping delete test")
+		} else {
+			err := cfg.timed(ctx, &res, func(ctx context.Context) error {
+				return doTestDeleteKey(ctx, client, name)
+			})
+			if err != nil {
+				res.fail(err)
+				if isDryRun(err) {
+					deleted = name
+				}
+			} else {
+				res.Success = true
+				created = ""
+				deleted = name
+				res.Notes = append(res.Notes, fmt.Sprintf("Key %s was deleted; if soft-delete is enabled it remains recoverable until purged", name))
+			}
+		}
+		if !record(res) {
+			return false
+		}
+	}
+
+	if cfg.getDeleted {
+		res := testResult{Operation: opGetDeleted}
+		if deleted == "" {
+			res.skip("Get deleted key testing requires a key deleted in this run; add -test-create and -test-delete")
+		} else {
+			var info *deletedKeyInfo
+			err := cfg.timedWait(ctx, &res, func(ctx context.Context) (retries int, err error) {
+				info, retries, err = doTestGetDeletedKey(ctx, client, cfg, deleted)
+				return retries, err
+			})
+			if err != nil {
+				res.fail(err)
+			} else {
+				res.Success = true
+				res.DeletedDate = info.deletedDate
+				res.ScheduledPurgeDate = info.scheduledPurgeDate
+			}
+		}
+		if !record(res) {
+			return false
+		}
+	}
+
+	if cfg.recover {
+		res := testResult{Operation: opRecover}
+		if deleted == "" {
+			res.skip("Recover testing requires a key deleted in this run; add -test-create and -test-delete")
+		} else {
+			err := cfg.timedWait(ctx, &res, func(ctx context.Context) (int, error) {
+				return doTestRecoverKey(ctx, client, cfg, deleted)
+			})
+			if err != nil {
+				res.fail(err)
+			} else {
+				res.Success = true
+				res.Notes = append(res.Notes, fmt.Sprintf("Key %s was recovered; it is deleted again before the run ends", deleted))
+				created, deleted = deleted, ""
+			}
+		}
+		if !record(res) {
+			return false
+		}
+
+		// The purge test needs the recovered key deleted again
+		if cfg.purge && created != "" {
+			if _, redeleteErr = cfg.retryWhileStatus(ctx, http.StatusConflict, func(ctx context.Context) error {
+				return doTestDeleteKey(ctx, client, created)
+			}); redeleteErr == nil {
+				created, deleted = "", created
+			}
+		}
+	}
+
+	if cfg.purge {
+		res := testResult{Operation: opPurge}
+		if redeleteErr != nil {
+			// The recovered key is live; clean it up now, so that a key left
+			// behind is reported rather than only logged
+			name := created
+			created = ""
+			reason := classifyError(redeleteErr).message
+			if err := cleanupTemporaryKey(context.WithoutCancel(ctx), client, cfg, name); err != nil {
+				res.fail(fmt.Errorf("purge was not tested and temporary key %s is left live: deleting it again after the recover test failed (%s), and so did cleanup; remove it manually: %w", name, reason, err))
+			} else {
+				res.skip(fmt.Sprintf("Purge was not tested: deleting temporary key %s again after the recover test failed (%s), leaving it live until cleanup deleted it", name, reason))
+			}
+		} else if deleted == "" {
+			res.skip("Purge testing requires a key deleted in this run; add -test-create and -test-delete")
+		} else {
+			res.Warnings = append(res.Warnings, fmt.Sprintf("Purge is irreversible: if permitted, %s is permanently destroyed and cannot be recovered", deleted))
+			err := cfg.timedWait(ctx, &res, func(ctx context.Context) (int, error) {
+				return doTestPurgeKey(ctx, client, cfg, deleted)
+			})
+			if err != nil {
+				res.fail(err)
+			} else {
+				res.Success = true
+			}
+		}
+		if !record(res) {
+			return false
+		}
+	}
+
+	if cfg.importKey {
+		res := testResult{Operation: opImport}
+		name, err := temporaryKeyName()
+		if err == nil {
+			var info *keyInfo
+			err = cfg.timed(ctx, &res, func(ctx context.Context) (err error) {
+				info, err = doTestImportKey(ctx, client, name, cfg.createKey)
+				return err
+			})
+			if err == nil {
+				imported = name
+				res.KeyID = info.keyID
+				res.KeyType = info.keyType
+				res.Curve = info.curve
+				res.Notes = append(res.Notes, fmt.Sprintf("Imported key %s is deleted at the end of the run", name))
+			}
+		}
+		if err != nil {
+			res.fail(err)
+		} else {
+			res.Success = true
+		}
+		if !record(res) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// cleanupTemporaryKey deletes a key left behind by the create test. It logs
+// a failure and returns it, for a caller that still has a result to report
+// it on.
+func cleanupTemporaryKey(ctx context.Context, client keyVaultClient, cfg testConfig, name string) error {
+	_, err := cfg.withRetry(ctx, func(ctx context.Context) error {
+		return doTestDeleteKey(ctx, client, name)
+	})
+	if err != nil {
+		slog.Warn("Failed to delete temporary key, remove it manually", "key", name, "error", classifyError(err).message)
+	}
+	return err
+}
+
+// temporaryKeyTags returns the tags of the keys created by the create and
+// import tests.
+func temporaryKeyTags() map[string]*string {
+	createdBy := "azkeyvault-perm-tester"
+	return map[string]*string{"createdBy": &createdBy}
+}
+
+func temporaryKeyName() (string, error) {
+	buf := make([]byte, 6)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate key name: %w", err)
+	}
+	return temporaryKeyPrefix + hex.EncodeToString(buf), nil
+}
+
+func doTestCreateKey(ctx context.Context, client keyVaultClient, keyName string, spec createKeySpec) (*keyInfo, error) {
+	params := azkeys.CreateKeyParameters{
+		Kty:  &spec.keyType,
+		Tags: temporaryKeyTags(),
+	}
+	if spec.size != 0 {
+		params.KeySize = &spec.size
+	}
+	if spec.curve != "" {
+		params.Curve = &spec.curve
+	}
+
+	resp, err := client.CreateKey(ctx, keyName, params, nil)
+	if err != nil {
+		return nil, fmt.Errorf("create key operation failed: %w", err)
+	}
+
+	info := &keyInfo{}
+	if resp.Key != nil {
+		if resp.Key.KID != nil {
+			info.keyID = string(*resp.Key.KID)
+		}
+		if resp.Key.Kty != nil {
+			info.keyType = string(*resp.Key.Kty)
+		}
+		if resp.Key.Crv != nil {
+			info.curve = string(*resp.Key.Crv)
+		}
+	}
+
+	return info, nil
+}
+
+// updateTestTag is the tag the update test adds to the temporary key and
+// then removes again.
+const updateTestTag = "permTestUpdated"
+
+// doTestUpdateKey adds updateTestTag to a temporary key, then restores its
+// original tags. Tags are benign: they change neither how the key can be
+// used nor its versions.
+func doTestUpdateKey(ctx context.Context, client keyVaultClient, keyName string) error {
+	tags := temporaryKeyTags()
+	value := "true"
+	tags[updateTestTag] = &value
+	if _, err := client.UpdateKey(ctx, keyName, "", azkeys.UpdateKeyParameters{Tags: tags}, nil); err != nil {
+		return fmt.Errorf("update key operation failed: %w", err)
+	}
+	if _, err := client.UpdateKey(ctx, keyName, "", azkeys.UpdateKeyParameters{Tags: temporaryKeyTags()}, nil); err != nil {
+		return fmt.Errorf("update key operation failed to restore the key's tags: %w", err)
+	}
+	return nil
+}
+
+func doTestDeleteKey(ctx context.Context, client keyVaultClient, keyName string) error {
+	_, err := client.DeleteKey(ctx, keyName, nil)
+	if err != nil {
+		return fmt.Errorf("delete key operation failed: %w", err)
+	}
+	return nil
+}
+
+// Key Vault deletes and recovers keys asynchronously. Until it is done, a
+// deleted key is not found (404) and purging or deleting it again conflicts
+// (409). Such requests are retried at softDeleteRetryInterval for up to
+// softDeleteWaitTimeout.
+const (
+	softDeleteRetryInterval = 2 * time.Second
+	softDeleteWaitTimeout   = time.Minute
+)
+
+// retryWhileStatus runs op like withRetry until it succeeds or fails with
+// anything but status, or softDeleteWaitTimeout has passed. Each attempt has
+// the per-operation timeout to itself, so the wait can outlast -timeout. It
+// returns the retries withRetry consumed.
+func (cfg testConfig) retryWhileStatus(ctx context.Context, status int, op func(ctx context.Context) error) (int, error) {
+	deadline := time.Now().Add(softDeleteWaitTimeout)
+	total := 0
+	for {
+		retries, err := cfg.withRetry(ctx, op)
+		total += retries
+		var respErr *azcore.ResponseError
+		if err == nil || !errors.As(err, &respErr) || respErr.StatusCode != status || time.Now().After(deadline) {
+			return total, err
+		}
+
+		timer := time.NewTimer(softDeleteRetryInterval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return total, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func doTestPurgeKey(ctx context.Context, client keyVaultClient, cfg testConfig, keyName string) (int, error) {
+	retries, err := cfg.retryWhileStatus(ctx, http.StatusConflict, func(ctx context.Context) error {
+		_, err := client.PurgeDeletedKey(ctx, keyName, nil)
+		return err
+	})
+	if err != nil {
+		return retries, fmt.Errorf("purge deleted key operation failed: %w", err)
+	}
+	return retries, nil
+}
+
+// deletedKeyInfo is what the get deleted key test reports.
+type deletedKeyInfo struct {
+	deletedDate        *time.Time
+	scheduledPurgeDate *time.Time
+}
+
+func doTestGetDeletedKey(ctx context.Context, client keyVaultClient, cfg testConfig, keyName string) (*deletedKeyInfo, int, error) {
+	var resp azkeys.GetDeletedKeyResponse
+	retries, err := cfg.retryWhileStatus(ctx, http.StatusNotFound, func(ctx context.Context) (err error) {
+		resp, err = client.GetDeletedKey(ctx, keyName, nil)
+		return err
+	})
+	if err != nil {
+		return nil, retries, fmt.Errorf("get deleted key operation failed: %w", err)
+	}
+	return &deletedKeyInfo{
+		deletedDate:        resp.DeletedDate,
+		scheduledPurgeDate: resp.ScheduledPurgeDate,
+	}, retries, nil
+}
+
+// doTestRecoverKey recovers a deleted key and waits until it can be used
+// again, so that it can be deleted once more.
+func doTestRecoverKey(ctx context.Context, client keyVaultClient, cfg testConfig, keyName string) (int, error) {
+	retries, err := cfg.retryWhileStatus(ctx, http.StatusConflict, func(ctx context.Context) error {
+		_, err := client.RecoverDeletedKey(ctx, keyName, nil)
+		return err
+	})
+	if err != nil {
+		return retries, fmt.Errorf("recover deleted key operation failed: %w", err)
+	}
+	more, err := cfg.retryWhileStatus(ctx, http.StatusNotFound, func(ctx context.Context) error {
+		_, err := client.GetKey(ctx, keyName, "", nil)
+		return err
+	})
+	retries += more
+	if err != nil {
+		return retries, fmt.Errorf("recovered key did not become available: %w", err)
+	}
+	return retries, nil
+}
+
+// ecCurves maps Key Vault curve names to the curves used to generate keys
+// for the import test.
+var ecCurves = map[azkeys.CurveName]elliptic.Curve{
+	azkeys.CurveNameP256: elliptic.P256(),
+	azkeys.CurveNameP384: elliptic.P384(),
+	azkeys.CurveNameP521: elliptic.P521(),
+}
+
+// generateImportKey generates a private key locally and returns it as a JWK
+// for the import test. HSM key types are generated as their software
+// equivalent; whether Key Vault stores the key in an HSM is requested with
+// ImportKeyParameters.HSM.
+func generateImportKey(spec createKeySpec) (*azkeys.JSONWebKey, error) {
+	if isRSAKeyType(string(spec.keyType)) {
+		priv, err := rsa.GenerateKey(rand.Reader, int(spec.size))
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate RSA key: %w", err)
+		}
+		priv.Precompute()
+		kty := azkeys.KeyTypeRSA
+		return &azkeys.JSONWebKey{
+			Kty: &kty,
+			N:   priv.N.Bytes(),
+			E:   big.NewInt(int64(priv.E)).Bytes(),
+			D:   priv.D.Bytes(),
+			P:   priv.Primes[0].Bytes(),
+			Q:   priv.Primes[1].Bytes(),
+			DP:  priv.Precomputed.Dp.Bytes(),
+			DQ:  priv.Precomputed.Dq.Bytes(),
+			QI:  priv.Precomputed.Qinv.Bytes(),
+		}, nil
+	}
+
+	curve, ok := ecCurves[spec.curve]
+	if !ok {
+		return nil, fmt.Errorf("curve %s cannot be generated locally", spec.curve)
+	}
+	priv, err := ecdsa.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate EC key: %w", err)
+	}
+	// JWK coordinates are fixed-length, left-padded with zeros
+	size := (curve.Params().BitSize + 7) / 8
+	kty := azkeys.KeyTypeEC
+	return &azkeys.JSONWebKey{
+		Kty: &kty,
+		Crv: &spec.curve,
+		X:   priv.X.FillBytes(make([]byte, size)),
+		Y:   priv.Y.FillBytes(make([]byte, size)),
+		D:   priv.D.FillBytes(make([]byte, size)),
+	}, nil
+}
+
+func doTestImportKey(ctx context.Context, client keyVaultClient, keyName string, spec createKeySpec) (*keyInfo, error) {
+	jwk, err := generateImportKey(spec)
+	if err != nil {
+		return nil, err
+	}
+	hsm := strings.HasSuffix(string(spec.keyType), "-HSM")
+	params := azkeys.ImportKeyParameters{
+		Key:  jwk,
+		HSM:  &hsm,
+		Tags: temporaryKeyTags(),
+	}
+
+	resp, err := client.ImportKey(ctx, keyName, params, nil)
+	if err != nil {
+		return nil, fmt.Errorf("import key operation failed: %w", err)
+	}
+
+	info := &keyInfo{}
+	if resp.Key != nil {
+		if resp.Key.KID != nil {
+			info.keyID = string(*resp.Key.KID)
+		}
+		if resp.Key.Kty != nil {
+			info.keyType = string(*resp.Key.Kty)
+		}
+		if resp.Key.Crv != nil {
+			info.curve = string(*resp.Key.Crv)
+		}
+	}
+
+	return info, nil
+}
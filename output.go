@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// atomicFile is a report file for -output-file. It is written under a
+// temporary name in the destination directory and renamed into place by
+// commit, so a run that crashes or is killed midway never leaves a partial
+// report behind at the path.
+type atomicFile struct {
+	*os.File
+	path string
+}
+
+// createAtomicFile starts writing the file at path, creating its parent
+// directories as needed.
+func createAtomicFile(path string) (*atomicFile, error) {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create output directory: %w", err)
+	}
+	f, err := os.CreateTemp(dir, "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create output file: %w", err)
+	}
+	// CreateTemp restricts the file to its owner; reports are not secret
+	if err := f.Chmod(0o644); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, fmt.Errorf("failed to create output file: %w", err)
+	}
+	return &atomicFile{File: f, path: path}, nil
+}
+
+// commit closes the file and moves it to its final path, replacing any
+// earlier report there.
+func (f *atomicFile) commit() error {
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("failed to write output file: %w", err)
+	}
+	if err := os.Rename(f.Name(), f.path); err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("failed to write output file: %w", err)
+	}
+	return nil
+}
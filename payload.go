@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bufio"
+	"crypto"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strings"
+
+	_ "crypto/sha256" // register the hashes of the signature algorithms
+	_ "crypto/sha512"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azkeys"
+
+	"azkeyvault-perm-tester/pkg/permtest"
+)
+
+// testMessage is signed when no -data-file, -data-stdin, or -digest-hex is
+// given, and is the plaintext for the encrypt test.
+var testMessage = []byte("Test message for Azure Key Vault signing and verification")
+
+// signPayload is what the sign and verify tests operate on: either data to
+// be hashed with the signature algorithm's digest, or a digest supplied
+// directly.
+type signPayload struct {
+	data   []byte
+	digest []byte
+
+	// digests holds the hashes of data streamed from -data-file or
+	// -data-stdin, which is never held in memory, keyed by hash.
+	digests map[crypto.Hash][]byte
+
+	// hash overrides the digest algorithm implied by the signature
+	// algorithm when non-zero.
+	hash crypto.Hash
+}
+
+// hashNames maps -hash values, with or without the dash, to digests.
+var hashNames = map[string]crypto.Hash{
+	"SHA256":  crypto.SHA256,
+	"SHA-256": crypto.SHA256,
+	"SHA384":  crypto.SHA384,
+	"SHA-384": crypto.SHA384,
+	"SHA512":  crypto.SHA512,
+	"SHA-512": crypto.SHA512,
+}
+
+// streamedHashes are computed for streamed data: every digest a signature
+// algorithm or -hash can select.
+var streamedHashes = []crypto.Hash{crypto.SHA256, crypto.SHA384, crypto.SHA512}
+
+// hashStream reads r to the end in chunks, feeding every hash in
+// streamedHashes in a single pass, so that inputs of any size can be signed
+// with any algorithm without being loaded into memory.
+func hashStream(r io.Reader) (map[crypto.Hash][]byte, error) {
+	hashers := make([]hash.Hash, len(streamedHashes))
+	writers := make([]io.Writer, len(streamedHashes))
+	for i, h := range streamedHashes {
+		hashers[i] = h.New()
+		writers[i] = hashers[i]
+	}
+	if _, err := io.Copy(io.MultiWriter(writers...), r); err != nil {
+		return nil, err
+	}
+	digests := make(map[crypto.Hash][]byte, len(streamedHashes))
+	for i, h := range streamedHashes {
+		digests[h] = hashers[i].Sum(nil)
+	}
+	return digests, nil
+}
+
+// Encodings of the data read from -data-file or -data-stdin.
+const (
+	dataEncodingUTF8   = "utf8"
+	dataEncodingHex    = "hex"
+	dataEncodingBase64 = "base64"
+)
+
+// parseDataEncoding validates a -data-encoding value.
+func parseDataEncoding(name string) (string, error) {
+	switch enc := strings.ToLower(name); enc {
+	case dataEncodingUTF8, dataEncodingHex, dataEncodingBase64:
+		return enc, nil
+	}
+	return "", fmt.Errorf("unknown data encoding %q (expected utf8, hex, or base64)", name)
+}
+
+// errorReader remembers the error of the reader it wraps, so that a failure
+// to read the data can be told apart from data that does not decode.
+type errorReader struct {
+	r   io.Reader
+	err error
+}
+
+func (e *errorReader) Read(p []byte) (int, error) {
+	n, err := e.r.Read(p)
+	if err != nil && err != io.EOF {
+		e.err = err
+	}
+	return n, err
+}
+
+// withoutWhitespace drops the whitespace, such as line breaks or a trailing
+// newline, from encoded text.
+type withoutWhitespace struct {
+	r *bufio.Reader
+}
+
+func (h withoutWhitespace) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		c, err := h.r.ReadByte()
+		if err != nil {
+			return n, err
+		}
+		if c != ' ' && c != '\t' && c != '\n' && c != '\r' {
+			p[n] = c
+			n++
+		}
+	}
+	return n, nil
+}
+
+// hashEncoded hashes the bytes r encodes in encoding, as hashStream does. The
+// text of hex and base64 may contain whitespace, e.g. a trailing newline; the
+// utf8 text is hashed as is, byte for byte. source names the input in errors.
+func hashEncoded(r io.Reader, encoding string, source string) (map[crypto.Hash][]byte, error) {
+	src := &errorReader{r: r}
+	var decoded io.Reader = src
+	switch encoding {
+	case dataEncodingHex:
+		decoded = hex.NewDecoder(withoutWhitespace{r: bufio.NewReader(src)})
+	case dataEncodingBase64:
+		decoded = base64.NewDecoder(base64.StdEncoding, withoutWhitespace{r: bufio.NewReader(src)})
+	}
+	digests, err := hashStream(decoded)
+	switch {
+	case src.err != nil:
+		return nil, fmt.Errorf("failed to read %s: %w", source, src.err)
+	case err != nil:
+		return nil, fmt.Errorf("%s is not valid %s: %w", source, encoding, err)
+	}
+	return digests, nil
+}
+
+// parseHash resolves a -hash value. An empty name means no override.
+func parseHash(name string) (crypto.Hash, error) {
+	if name == "" {
+		return 0, nil
+	}
+	if hash, ok := hashNames[strings.ToUpper(name)]; ok {
+		return hash, nil
+	}
+	return 0, fmt.Errorf("unknown hash %q (expected SHA256, SHA384, or SHA512)", name)
+}
+
+// loadSignPayload reads the payload selected on the command line. At most
+// one of dataFile, fromStdin, and digestHex may be set. The data of dataFile
+// or stdin is decoded from encoding before it is hashed.
+//
+// The returned payload hashes with the signature algorithm's digest; set
+// its hash field to override that.
+func loadSignPayload(dataFile string, fromStdin bool, digestHex string, encoding string, stdin io.Reader) (signPayload, error) {
+	sources := 0
+	for _, set := range []bool{dataFile != "", fromStdin, digestHex != ""} {
+		if set {
+			sources++
+		}
+	}
+	if sources > 1 {
+		return signPayload{}, errors.New("-data-file, -data-stdin, and -digest-hex are mutually exclusive")
+	}
+
+	switch {
+	case dataFile != "":
+		f, err := os.Open(dataFile)
+		if err != nil {
+			return signPayload{}, fmt.Errorf("failed to read data file: %w", err)
+		}
+		defer f.Close()
+		digests, err := hashEncoded(f, encoding, "data file")
+		if err != nil {
+			return signPayload{}, err
+		}
+		return signPayload{digests: digests}, nil
+	case fromStdin:
+		digests, err := hashEncoded(stdin, encoding, "data from stdin")
+		if err != nil {
+			return signPayload{}, err
+		}
+		return signPayload{digests: digests}, nil
+	case digestHex != "":
+		digest, err := hex.DecodeString(digestHex)
+		if err != nil {
+			return signPayload{}, fmt.Errorf("-digest-hex is not valid hex: %w", err)
+		}
+		return signPayload{digest: digest}, nil
+	}
+	return signPayload{data: testMessage}, nil
+}
+
+// digestFor returns the digest to sign with algorithm, hashing the data with
+// the algorithm's hash or checking that a supplied digest has its length.
+func (p signPayload) digestFor(algorithm azkeys.SignatureAlgorithm) ([]byte, error) {
+	hash, ok := permtest.HashForAlgorithm(algorithm)
+	if p.hash != 0 {
+		hash, ok = p.hash, true
+	}
+	if !ok {
+		return nil, fmt.Errorf("signature algorithm %s is not supported", algorithm)
+	}
+
+	if p.digest != nil {
+		if len(p.digest) != hash.Size() {
+			return nil, fmt.Errorf("digest is %d bytes, but %s with %s expects %d bytes", len(p.digest), algorithm, hash, hash.Size())
+		}
+		return p.digest, nil
+	}
+	if p.digests != nil {
+		digest, ok := p.digests[hash]
+		if !ok {
+			return nil, fmt.Errorf("hash %s is not supported", hash)
+		}
+		return digest, nil
+	}
+
+	h := hash.New()
+	h.Write(p.data)
+	return h.Sum(nil), nil
+}
+
+// hashWarning explains when a -hash override differs from the digest the
+// signature algorithm is defined over, or returns "" when it does not.
+func (p signPayload) hashWarning(algorithm azkeys.SignatureAlgorithm) string {
+	expected, ok := permtest.HashForAlgorithm(algorithm)
+	if p.hash == 0 || !ok || p.hash == expected {
+		return ""
+	}
+	return fmt.Sprintf("Hash %s overrides %s, which %s is defined over; Key Vault may reject the digest", p.hash, expected, algorithm)
+}
@@ -0,0 +1,143 @@
+package permtest
+
+import (
+	"crypto"
+	"fmt"
+	"slices"
+	"strings"
+
+	// Register the hashes Digest uses
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azkeys"
+)
+
+// Ed25519 keys and the EdDSA algorithm are newer than the azkeys module in
+// use, which has no constants for them; Key Vault accepts them by name.
+const (
+	SignatureAlgorithmEdDSA = azkeys.SignatureAlgorithm("EdDSA")
+	KeyTypeOKP              = azkeys.KeyType("OKP")
+	KeyTypeOKPHSM           = azkeys.KeyType("OKP-HSM")
+	CurveNameEd25519        = azkeys.CurveName("Ed25519")
+)
+
+// AESBlockSize is the AES block size, and the IV size of AES-CBC.
+const AESBlockSize = 16
+
+// IsGCM reports whether alg is one of the AES-GCM algorithms.
+func IsGCM(alg azkeys.EncryptionAlgorithm) bool {
+	return strings.HasSuffix(string(alg), "GCM")
+}
+
+// IsCBC reports whether alg is one of the AES-CBC algorithms, with or
+// without padding.
+func IsCBC(alg azkeys.EncryptionAlgorithm) bool {
+	return strings.Contains(string(alg), "CBC")
+}
+
+var rsaSignatureAlgorithms = []azkeys.SignatureAlgorithm{
+	azkeys.SignatureAlgorithmRS256,
+	azkeys.SignatureAlgorithmRS384,
+	azkeys.SignatureAlgorithmRS512,
+	azkeys.SignatureAlgorithmPS256,
+	azkeys.SignatureAlgorithmPS384,
+	azkeys.SignatureAlgorithmPS512,
+}
+
+// ecSignatureAlgorithms maps each EC curve to the one algorithm defined for it.
+var ecSignatureAlgorithms = map[azkeys.CurveName]azkeys.SignatureAlgorithm{
+	azkeys.CurveNameP256:  azkeys.SignatureAlgorithmES256,
+	azkeys.CurveNameP256K: azkeys.SignatureAlgorithmES256K,
+	azkeys.CurveNameP384:  azkeys.SignatureAlgorithmES384,
+	azkeys.CurveNameP521:  azkeys.SignatureAlgorithmES512,
+}
+
+// SignatureAlgorithms returns the signature algorithms usable with a key of
+// the given type and curve, software or HSM. The first entry is the
+// preferred default. It returns nil for keys that cannot sign.
+func SignatureAlgorithms(keyType azkeys.KeyType, curve azkeys.CurveName) []azkeys.SignatureAlgorithm {
+	switch keyType {
+	case azkeys.KeyTypeRSA, azkeys.KeyTypeRSAHSM:
+		return slices.Clone(rsaSignatureAlgorithms)
+	case azkeys.KeyTypeEC, azkeys.KeyTypeECHSM:
+		if alg, ok := ecSignatureAlgorithms[curve]; ok {
+			return []azkeys.SignatureAlgorithm{alg}
+		}
+	case KeyTypeOKP, KeyTypeOKPHSM:
+		if curve == CurveNameEd25519 {
+			return []azkeys.SignatureAlgorithm{SignatureAlgorithmEdDSA}
+		}
+	}
+	return nil
+}
+
+// HashForAlgorithm returns the digest algorithm a signature algorithm is
+// defined over. EdDSA signs its input directly rather than a digest; the
+// input given to it here is the SHA-512 digest of the data, so that large
+// data never has to be held in memory.
+func HashForAlgorithm(alg azkeys.SignatureAlgorithm) (crypto.Hash, bool) {
+	switch alg {
+	case azkeys.SignatureAlgorithmRS256, azkeys.SignatureAlgorithmPS256,
+		azkeys.SignatureAlgorithmES256, azkeys.SignatureAlgorithmES256K:
+		return crypto.SHA256, true
+	case azkeys.SignatureAlgorithmRS384, azkeys.SignatureAlgorithmPS384, azkeys.SignatureAlgorithmES384:
+		return crypto.SHA384, true
+	case azkeys.SignatureAlgorithmRS512, azkeys.SignatureAlgorithmPS512, azkeys.SignatureAlgorithmES512,
+		SignatureAlgorithmEdDSA:
+		return crypto.SHA512, true
+	}
+	return 0, false
+}
+
+// Digest hashes data with the digest algorithm alg is defined over, giving
+// the input Sign and Verify expect. It fails for an algorithm it does not
+// know, rather than guess a hash Key Vault would reject.
+func Digest(alg azkeys.SignatureAlgorithm, data []byte) ([]byte, error) {
+	hash, ok := HashForAlgorithm(alg)
+	if !ok {
+		return nil, fmt.Errorf("signature algorithm %s is not supported", alg)
+	}
+	h := hash.New()
+	h.Write(data)
+	return h.Sum(nil), nil
+}
+
+// forKey fills in the algorithms o leaves empty with defaults for key: the
+// preferred signature algorithm of its type and curve, RSA-OAEP-256 for RSA
+// keys, and A256GCM and A256KW for symmetric keys. Algorithms the key cannot
+// perform stay empty. A nil key, whose type is unknown, gets the RSA
+// defaults.
+func (o Options) forKey(key *azkeys.JSONWebKey) Options {
+	keyType := azkeys.KeyTypeRSA
+	var curve azkeys.CurveName
+	if key != nil {
+		keyType = ""
+		if key.Kty != nil {
+			keyType = *key.Kty
+		}
+		if key.Crv != nil {
+			curve = *key.Crv
+		}
+	}
+
+	if o.SignatureAlgorithm == "" {
+		if algs := SignatureAlgorithms(keyType, curve); len(algs) > 0 {
+			o.SignatureAlgorithm = algs[0]
+		}
+	}
+	var encryption, wrap azkeys.EncryptionAlgorithm
+	switch keyType {
+	case azkeys.KeyTypeRSA, azkeys.KeyTypeRSAHSM:
+		encryption, wrap = azkeys.EncryptionAlgorithmRSAOAEP256, azkeys.EncryptionAlgorithmRSAOAEP256
+	case azkeys.KeyTypeOct, azkeys.KeyTypeOctHSM:
+		encryption, wrap = azkeys.EncryptionAlgorithmA256GCM, azkeys.EncryptionAlgorithmA256KW
+	}
+	if o.EncryptionAlgorithm == "" {
+		o.EncryptionAlgorithm = encryption
+	}
+	if o.WrapAlgorithm == "" {
+		o.WrapAlgorithm = wrap
+	}
+	return o
+}
@@ -0,0 +1,221 @@
+package permtest
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azkeys"
+)
+
+// GetKey reads the key, which needs the get permission. It returns the key
+// Key Vault sent, which is empty if the request failed.
+func (t *Tester) GetKey(ctx context.Context) (Result, azkeys.KeyBundle) {
+	res := Result{Operation: OperationGet}
+	var key azkeys.KeyBundle
+	t.do(ctx, &res, func(ctx context.Context) error {
+		resp, err := t.client.GetKey(ctx, t.opts.KeyName, t.opts.KeyVersion, nil)
+		if err != nil {
+			return fmt.Errorf("get key operation failed: %w", err)
+		}
+		key = resp.KeyBundle
+		if key.Key != nil {
+			res.setKID(key.Key.KID)
+		}
+		return nil
+	})
+	return res, key
+}
+
+// Sign signs digest with the key. It returns the signature, or nil if the
+// request failed.
+func (t *Tester) Sign(ctx context.Context, digest []byte) (Result, []byte) {
+	res := Result{Operation: OperationSign}
+	alg := t.opts.SignatureAlgorithm
+	var signature []byte
+	t.do(ctx, &res, func(ctx context.Context) error {
+		params := azkeys.SignParameters{
+			Algorithm: &alg,
+			Value:     digest,
+		}
+		resp, err := t.client.Sign(ctx, t.opts.KeyName, t.opts.KeyVersion, params, nil)
+		if err != nil {
+			return fmt.Errorf("sign operation failed: %w", checkAlgorithmSupport(err, alg))
+		}
+		signature = resp.Result
+		res.setKID(resp.KID)
+		return nil
+	})
+	return res, signature
+}
+
+// Verify asks Key Vault whether signature is valid for digest. An invalid
+// signature is not a failure: the identity was permitted to verify it.
+func (t *Tester) Verify(ctx context.Context, digest []byte, signature []byte) (Result, bool) {
+	res := Result{Operation: OperationVerify}
+	alg := t.opts.SignatureAlgorithm
+	var valid bool
+	t.do(ctx, &res, func(ctx context.Context) error {
+		params := azkeys.VerifyParameters{
+			Algorithm: &alg,
+			Digest:    digest,
+			Signature: signature,
+		}
+		resp, err := t.client.Verify(ctx, t.opts.KeyName, t.opts.KeyVersion, params, nil)
+		if err != nil {
+			return fmt.Errorf("verify operation failed: %w", checkAlgorithmSupport(err, alg))
+		}
+		valid = resp.Value != nil && *resp.Value
+		return nil
+	})
+	return res, valid
+}
+
+// Encrypt encrypts plaintext with the key. AES-CBC is given a fresh random
+// IV, and AES-GCM is given aad; Managed HSM chooses the GCM IV itself. If the
+// request fails, the returned Ciphertext still carries the IV that was sent.
+func (t *Tester) Encrypt(ctx context.Context, plaintext []byte, aad []byte) (Result, Ciphertext) {
+	res := Result{Operation: OperationEncrypt}
+	alg := t.opts.EncryptionAlgorithm
+	params := azkeys.KeyOperationParameters{
+		Algorithm: &alg,
+		Value:     plaintext,
+	}
+	switch {
+	case IsCBC(alg):
+		params.IV = make([]byte, AESBlockSize)
+		if _, err := rand.Read(params.IV); err != nil {
+			res.setErr(fmt.Errorf("failed to generate IV: %w", err))
+			return res, Ciphertext{}
+		}
+	case IsGCM(alg):
+		params.AdditionalAuthenticatedData = aad
+	}
+
+	data := Ciphertext{IV: params.IV}
+	t.do(ctx, &res, func(ctx context.Context) error {
+		resp, err := t.client.Encrypt(ctx, t.opts.KeyName, t.opts.KeyVersion, params, nil)
+		if err != nil {
+			return fmt.Errorf("encrypt operation failed: %w", err)
+		}
+		// Key Vault echoes the IV of AES-CBC; keep the one sent if it does not
+		data = Ciphertext{Value: resp.Result, IV: resp.IV, AuthenticationTag: resp.AuthenticationTag}
+		if data.IV == nil {
+			data.IV = params.IV
+		}
+		res.setKID(resp.KID)
+		return nil
+	})
+	return res, data
+}
+
+// Decrypt decrypts data with the key, passing back its IV and
+// authentication tag and, for AES-GCM, aad. It returns the plaintext, or nil
+// if the request failed.
+func (t *Tester) Decrypt(ctx context.Context, data Ciphertext, aad []byte) (Result, []byte) {
+	res := Result{Operation: OperationDecrypt}
+	alg := t.opts.EncryptionAlgorithm
+	params := azkeys.KeyOperationParameters{
+		Algorithm: &alg,
+		Value:     data.Value,
+		IV:        data.IV,
+	}
+	if IsGCM(alg) {
+		params.AuthenticationTag = data.AuthenticationTag
+		params.AdditionalAuthenticatedData = aad
+	}
+
+	var plaintext []byte
+	t.do(ctx, &res, func(ctx context.Context) error {
+		resp, err := t.client.Decrypt(ctx, t.opts.KeyName, t.opts.KeyVersion, params, nil)
+		if err != nil {
+			return fmt.Errorf("decrypt operation failed: %w", err)
+		}
+		plaintext = resp.Result
+		res.setKID(resp.KID)
+		return nil
+	})
+	return res, plaintext
+}
+
+// WrapKey wraps key with the key under test. It returns the wrapped key, or
+// nil if the request failed.
+func (t *Tester) WrapKey(ctx context.Context, key []byte) (Result, []byte) {
+	res := Result{Operation: OperationWrapKey}
+	alg := t.opts.WrapAlgorithm
+	var wrapped []byte
+	t.do(ctx, &res, func(ctx context.Context) error {
+		params := azkeys.KeyOperationParameters{
+			Algorithm: &alg,
+			Value:     key,
+		}
+		resp, err := t.client.WrapKey(ctx, t.opts.KeyName, t.opts.KeyVersion, params, nil)
+		if err != nil {
+			return fmt.Errorf("wrap key operation failed: %w", err)
+		}
+		wrapped = resp.Result
+		res.setKID(resp.KID)
+		return nil
+	})
+	return res, wrapped
+}
+
+// UnwrapKey unwraps a key wrapped by the key under test. It returns the
+// unwrapped key, or nil if the request failed.
+func (t *Tester) UnwrapKey(ctx context.Context, wrapped []byte) (Result, []byte) {
+	res := Result{Operation: OperationUnwrapKey}
+	alg := t.opts.WrapAlgorithm
+	var key []byte
+	t.do(ctx, &res, func(ctx context.Context) error {
+		params := azkeys.KeyOperationParameters{
+			Algorithm: &alg,
+			Value:     wrapped,
+		}
+		resp, err := t.client.UnwrapKey(ctx, t.opts.KeyName, t.opts.KeyVersion, params, nil)
+		if err != nil {
+			return fmt.Errorf("unwrap key operation failed: %w", err)
+		}
+		key = resp.Result
+		res.setKID(resp.KID)
+		return nil
+	})
+	return res, key
+}
+
+// checkAlgorithmSupport wraps a 400 response to an EdDSA request in an
+// UnsupportedAlgorithmError, and returns any other error unchanged.
+func checkAlgorithmSupport(err error, alg azkeys.SignatureAlgorithm) error {
+	var respErr *azcore.ResponseError
+	if alg == SignatureAlgorithmEdDSA && errors.As(err, &respErr) && respErr.StatusCode == http.StatusBadRequest {
+		return &UnsupportedAlgorithmError{Algorithm: alg, Err: err}
+	}
+	return err
+}
+
+// setKID records the key version Key Vault reports using.
+func (r *Result) setKID(kid *azkeys.ID) {
+	if kid == nil {
+		return
+	}
+	r.KeyID = string(*kid)
+	r.KeyVersion = kid.Version()
+}
+
+// requireTrue fails a successful verify that rejected a valid signature.
+func (r *Result) requireTrue(valid bool) {
+	if r.Success && !valid {
+		r.setErr(fmt.Errorf("verify rejected a valid signature: %w", ErrMismatch))
+	}
+}
+
+// requireEqual fails a successful decrypt or unwrap that did not return the
+// original data, explaining why with problem.
+func (r *Result) requireEqual(problem string, got, want []byte) {
+	if r.Success && !bytes.Equal(got, want) {
+		r.setErr(fmt.Errorf("%s: %w", problem, ErrMismatch))
+	}
+}
@@ -0,0 +1,285 @@
+// Package permtest tests which cryptographic operations an identity may
+// perform with an Azure Key Vault or Managed HSM key. It is the core of the
+// azkeyvault-perm-tester command, for programs that want to check
+// permissions themselves rather than run the command and parse its output.
+//
+// A Tester sends each operation to Key Vault with the client it was given,
+// which carries the identity under test, and reports the outcome as a
+// Result:
+//
+//	client, err := azkeys.NewClient(vaultURL, cred, nil)
+//	...
+//	t := permtest.New(client, permtest.Options{KeyName: "my-key"})
+//	for _, res := range t.Run(ctx) {
+//		if res.Forbidden() {
+//			log.Printf("%s denied: %v", res.Operation, res.Err)
+//		}
+//	}
+//
+// Each operation is also available on its own, for callers that supply their
+// own digests, plaintexts, or keys to wrap.
+package permtest
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azkeys"
+)
+
+// Client is the part of *azkeys.Client a Tester uses. Tests of code that
+// embeds a Tester can pass a fake that returns canned responses and errors.
+type Client interface {
+	GetKey(ctx context.Context, name string, version string, options *azkeys.GetKeyOptions) (azkeys.GetKeyResponse, error)
+	Sign(ctx context.Context, name string, version string, parameters azkeys.SignParameters, options *azkeys.SignOptions) (azkeys.SignResponse, error)
+	Verify(ctx context.Context, name string, version string, parameters azkeys.VerifyParameters, options *azkeys.VerifyOptions) (azkeys.VerifyResponse, error)
+	Encrypt(ctx context.Context, name string, version string, parameters azkeys.KeyOperationParameters, options *azkeys.EncryptOptions) (azkeys.EncryptResponse, error)
+	Decrypt(ctx context.Context, name string, version string, parameters azkeys.KeyOperationParameters, options *azkeys.DecryptOptions) (azkeys.DecryptResponse, error)
+	WrapKey(ctx context.Context, name string, version string, parameters azkeys.KeyOperationParameters, options *azkeys.WrapKeyOptions) (azkeys.WrapKeyResponse, error)
+	UnwrapKey(ctx context.Context, name string, version string, parameters azkeys.KeyOperationParameters, options *azkeys.UnwrapKeyOptions) (azkeys.UnwrapKeyResponse, error)
+}
+
+var _ Client = (*azkeys.Client)(nil)
+
+// Names of the operations a Tester performs, as reported in Result.Operation.
+// They match the operation names in the command's JSON output.
+const (
+	OperationGet       = "get"
+	OperationSign      = "sign"
+	OperationVerify    = "verify"
+	OperationEncrypt   = "encrypt"
+	OperationDecrypt   = "decrypt"
+	OperationWrapKey   = "wrapKey"
+	OperationUnwrapKey = "unwrapKey"
+)
+
+// Options configures a Tester. Only KeyName is required.
+type Options struct {
+	// KeyName is the key to test.
+	KeyName string
+
+	// KeyVersion pins the version of the key to test. Empty means the
+	// latest version.
+	KeyVersion string
+
+	// SignatureAlgorithm is used by Sign and Verify. Run defaults it to
+	// the preferred algorithm of the key type GetKey reports, such as ES256
+	// for a P-256 key; Sign and Verify on their own default to RS256.
+	SignatureAlgorithm azkeys.SignatureAlgorithm
+
+	// EncryptionAlgorithm is used by Encrypt and Decrypt. Run defaults it
+	// to RSA-OAEP-256 for RSA keys and A256GCM for symmetric keys; Encrypt
+	// and Decrypt on their own default to RSA-OAEP-256.
+	EncryptionAlgorithm azkeys.EncryptionAlgorithm
+
+	// WrapAlgorithm is used by WrapKey and UnwrapKey. Run defaults it to
+	// RSA-OAEP-256 for RSA keys and A256KW for symmetric keys; WrapKey and
+	// UnwrapKey on their own default to RSA-OAEP-256.
+	WrapAlgorithm azkeys.EncryptionAlgorithm
+
+	// Timeout bounds each request to Key Vault. Zero means no limit beyond
+	// the caller's context.
+	Timeout time.Duration
+}
+
+// Tester tests the permissions of the identity behind its client on one key.
+// It is safe for concurrent use if its client is.
+type Tester struct {
+	client Client
+
+	// given is the Options New was passed, and opts the same with the RSA
+	// defaults filled in
+	given Options
+	opts  Options
+}
+
+// New returns a Tester that sends its requests with client.
+func New(client Client, opts Options) *Tester {
+	return &Tester{client: client, given: opts, opts: opts.forKey(nil)}
+}
+
+// Result is the outcome of one operation.
+type Result struct {
+	// Operation is one of the Operation constants.
+	Operation string
+
+	// Success reports whether Key Vault performed the operation and, for
+	// the checks Run makes, returned the expected answer.
+	Success bool
+
+	// Skipped reports that Run did not attempt the operation, because the
+	// operation that produces its input failed or the key's type cannot
+	// perform it, as with encryption by an EC key.
+	Skipped bool
+
+	// Err is why the operation failed. It wraps the *azcore.ResponseError
+	// of a request Key Vault rejected, or ErrMismatch.
+	Err error
+
+	// StatusCode and ErrorCode are the HTTP status and Key Vault error code
+	// of a rejected request, such as 403 and "Forbidden".
+	StatusCode int
+	ErrorCode  string
+
+	// KeyID and KeyVersion identify the key version Key Vault used. The
+	// operations that do not name the key in their response leave KeyID
+	// empty.
+	KeyID      string
+	KeyVersion string
+
+	// Duration is how long the request took.
+	Duration time.Duration
+}
+
+// Forbidden reports whether Key Vault denied the operation: the identity
+// lacks the RBAC role or access policy permission, or the vault's firewall
+// rejected the request.
+func (r Result) Forbidden() bool {
+	return r.StatusCode == http.StatusForbidden
+}
+
+// ErrMismatch is the error of an operation Key Vault performed but that gave
+// the wrong answer, such as a decryption that does not return the plaintext
+// that was encrypted.
+var ErrMismatch = errors.New("result does not match")
+
+// UnsupportedAlgorithmError is a request Key Vault rejected because the
+// vault does not offer its algorithm yet, as with EdDSA before Ed25519
+// support reaches it.
+type UnsupportedAlgorithmError struct {
+	Algorithm azkeys.SignatureAlgorithm
+	Err       error
+}
+
+func (e *UnsupportedAlgorithmError) Error() string {
+	return string(e.Algorithm) + " is not supported: " + e.Err.Error()
+}
+
+func (e *UnsupportedAlgorithmError) Unwrap() error { return e.Err }
+
+// Ciphertext is the output of an encryption: the ciphertext and, for the
+// AES algorithms, the IV and authentication tag that decryption needs back.
+type Ciphertext struct {
+	Value             []byte
+	IV                []byte
+	AuthenticationTag []byte
+}
+
+// runMessage is the data Run signs, encrypts, and wraps. It is a whole
+// number of AES blocks, so that every encryption algorithm takes it as is.
+var runMessage = []byte("azkeyvault-perm-tester run test!")
+
+// Run tests every operation in turn: GET, sign and verify, encrypt and
+// decrypt, and wrap and unwrap. Algorithms Options leaves empty are chosen
+// for the key type GetKey reports, and operations the key cannot perform
+// are skipped; if GET fails, the RSA defaults are used. Verify, decrypt, and
+// unwrap check the output of the operation before them, and are skipped if
+// it failed. Run stops early only if ctx is done.
+func (t *Tester) Run(ctx context.Context) []Result {
+	var results []Result
+	add := func(res Result) bool {
+		results = append(results, res)
+		return ctx.Err() == nil
+	}
+
+	res, key := t.GetKey(ctx)
+	if !add(res) {
+		return results
+	}
+	if res.Success {
+		t = &Tester{client: t.client, given: t.given, opts: t.given.forKey(key.Key)}
+	}
+
+	var digest, signature []byte
+	var err error
+	if t.opts.SignatureAlgorithm == "" {
+		res = Result{Operation: OperationSign, Skipped: true}
+	} else if digest, err = Digest(t.opts.SignatureAlgorithm, runMessage); err != nil {
+		res = Result{Operation: OperationSign}
+		res.setErr(err)
+	} else {
+		res, signature = t.Sign(ctx, digest)
+	}
+	if !add(res) {
+		return results
+	}
+	if res.Success {
+		var valid bool
+		res, valid = t.Verify(ctx, digest, signature)
+		res.requireTrue(valid)
+	} else {
+		res = Result{Operation: OperationVerify, Skipped: true}
+	}
+	if !add(res) {
+		return results
+	}
+
+	var ciphertext Ciphertext
+	if t.opts.EncryptionAlgorithm == "" {
+		res = Result{Operation: OperationEncrypt, Skipped: true}
+	} else {
+		res, ciphertext = t.Encrypt(ctx, runMessage, nil)
+	}
+	if !add(res) {
+		return results
+	}
+	if res.Success {
+		var plaintext []byte
+		res, plaintext = t.Decrypt(ctx, ciphertext, nil)
+		res.requireEqual("decrypt did not return the plaintext that was encrypted", plaintext, runMessage)
+	} else {
+		res = Result{Operation: OperationDecrypt, Skipped: true}
+	}
+	if !add(res) {
+		return results
+	}
+
+	var wrapped []byte
+	if t.opts.WrapAlgorithm == "" {
+		res = Result{Operation: OperationWrapKey, Skipped: true}
+	} else {
+		res, wrapped = t.WrapKey(ctx, runMessage)
+	}
+	if !add(res) {
+		return results
+	}
+	if res.Success {
+		var unwrapped []byte
+		res, unwrapped = t.UnwrapKey(ctx, wrapped)
+		res.requireEqual("unwrap did not return the key that was wrapped", unwrapped, runMessage)
+	} else {
+		res = Result{Operation: OperationUnwrapKey, Skipped: true}
+	}
+	add(res)
+	return results
+}
+
+// do runs one request, timing it and recording its error on res.
+func (t *Tester) do(ctx context.Context, res *Result, request func(ctx context.Context) error) {
+	if t.opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, t.opts.Timeout)
+		defer cancel()
+	}
+	start := time.Now()
+	err := request(ctx)
+	res.Duration = time.Since(start)
+	if err != nil {
+		res.setErr(err)
+		return
+	}
+	res.Success = true
+}
+
+func (r *Result) setErr(err error) {
+	r.Success = false
+	r.Err = err
+	var respErr *azcore.ResponseError
+	if errors.As(err, &respErr) {
+		r.StatusCode = respErr.StatusCode
+		r.ErrorCode = respErr.ErrorCode
+	}
+}
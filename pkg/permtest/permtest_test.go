@@ -0,0 +1,376 @@
+package permtest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azkeys"
+)
+
+// fakeKID is the key ID fakeClient reports using.
+const fakeKID = "https://fake.vault.azure.net/keys/fake-key/0123456789abcdef"
+
+// fakeClient is a Client that answers from canned responses instead of Key
+// Vault. Its encryption and wrapping return their input unchanged, so that
+// round trips pass, and verify accepts every signature.
+type fakeClient struct {
+	// key is what GetKey returns; nil means an RSA-HSM key
+	key *azkeys.JSONWebKey
+
+	// errs fails the operations it names with its error
+	errs map[string]error
+
+	// wrong makes verify reject every signature, and decrypt and unwrap
+	// return a corrupted copy of their input
+	wrong map[string]bool
+
+	// mu guards algorithms
+	mu sync.Mutex
+
+	// algorithms records the operations attempted, with the algorithm each
+	// was sent with
+	algorithms map[string]string
+}
+
+var _ Client = (*fakeClient)(nil)
+
+// call records op and alg and returns the canned error of op.
+func (f *fakeClient) call(op string, alg string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.algorithms == nil {
+		f.algorithms = make(map[string]string)
+	}
+	f.algorithms[op] = alg
+	return f.errs[op]
+}
+
+// output returns data as the operation op produces it: unchanged, or with
+// its first byte flipped for the operations in wrong.
+func (f *fakeClient) output(op string, data []byte) []byte {
+	out := bytes.Clone(data)
+	if f.wrong[op] && len(out) > 0 {
+		out[0] ^= 0xff
+	}
+	return out
+}
+
+func fakeKeyID() *azkeys.ID {
+	kid := azkeys.ID(fakeKID)
+	return &kid
+}
+
+func (f *fakeClient) GetKey(ctx context.Context, name string, version string, options *azkeys.GetKeyOptions) (azkeys.GetKeyResponse, error) {
+	if err := f.call(OperationGet, ""); err != nil {
+		return azkeys.GetKeyResponse{}, err
+	}
+	key := azkeys.JSONWebKey{Kty: to(azkeys.KeyTypeRSAHSM)}
+	if f.key != nil {
+		key = *f.key
+	}
+	key.KID = fakeKeyID()
+	return azkeys.GetKeyResponse{KeyBundle: azkeys.KeyBundle{Key: &key}}, nil
+}
+
+func (f *fakeClient) Sign(ctx context.Context, name string, version string, parameters azkeys.SignParameters, options *azkeys.SignOptions) (azkeys.SignResponse, error) {
+	if err := f.call(OperationSign, string(*parameters.Algorithm)); err != nil {
+		return azkeys.SignResponse{}, err
+	}
+	return azkeys.SignResponse{KeyOperationResult: azkeys.KeyOperationResult{KID: fakeKeyID(), Result: []byte("signature")}}, nil
+}
+
+func (f *fakeClient) Verify(ctx context.Context, name string, version string, parameters azkeys.VerifyParameters, options *azkeys.VerifyOptions) (azkeys.VerifyResponse, error) {
+	if err := f.call(OperationVerify, string(*parameters.Algorithm)); err != nil {
+		return azkeys.VerifyResponse{}, err
+	}
+	return azkeys.VerifyResponse{KeyVerifyResult: azkeys.KeyVerifyResult{Value: to(!f.wrong[OperationVerify])}}, nil
+}
+
+func (f *fakeClient) Encrypt(ctx context.Context, name string, version string, parameters azkeys.KeyOperationParameters, options *azkeys.EncryptOptions) (azkeys.EncryptResponse, error) {
+	if err := f.call(OperationEncrypt, string(*parameters.Algorithm)); err != nil {
+		return azkeys.EncryptResponse{}, err
+	}
+	return azkeys.EncryptResponse{KeyOperationResult: azkeys.KeyOperationResult{KID: fakeKeyID(), Result: bytes.Clone(parameters.Value)}}, nil
+}
+
+func (f *fakeClient) Decrypt(ctx context.Context, name string, version string, parameters azkeys.KeyOperationParameters, options *azkeys.DecryptOptions) (azkeys.DecryptResponse, error) {
+	if err := f.call(OperationDecrypt, string(*parameters.Algorithm)); err != nil {
+		return azkeys.DecryptResponse{}, err
+	}
+	return azkeys.DecryptResponse{KeyOperationResult: azkeys.KeyOperationResult{KID: fakeKeyID(), Result: f.output(OperationDecrypt, parameters.Value)}}, nil
+}
+
+func (f *fakeClient) WrapKey(ctx context.Context, name string, version string, parameters azkeys.KeyOperationParameters, options *azkeys.WrapKeyOptions) (azkeys.WrapKeyResponse, error) {
+	if err := f.call(OperationWrapKey, string(*parameters.Algorithm)); err != nil {
+		return azkeys.WrapKeyResponse{}, err
+	}
+	return azkeys.WrapKeyResponse{KeyOperationResult: azkeys.KeyOperationResult{KID: fakeKeyID(), Result: bytes.Clone(parameters.Value)}}, nil
+}
+
+func (f *fakeClient) UnwrapKey(ctx context.Context, name string, version string, parameters azkeys.KeyOperationParameters, options *azkeys.UnwrapKeyOptions) (azkeys.UnwrapKeyResponse, error) {
+	if err := f.call(OperationUnwrapKey, string(*parameters.Algorithm)); err != nil {
+		return azkeys.UnwrapKeyResponse{}, err
+	}
+	return azkeys.UnwrapKeyResponse{KeyOperationResult: azkeys.KeyOperationResult{KID: fakeKeyID(), Result: f.output(OperationUnwrapKey, parameters.Value)}}, nil
+}
+
+func to[T any](v T) *T {
+	return &v
+}
+
+// forbidden builds the *azcore.ResponseError the SDK returns for the 403 Key
+// Vault sends an identity without permission.
+func forbidden() error {
+	body := `{"error":{"code":"Forbidden","message":"Caller is not authorized to perform action on resource."}}`
+	req, err := http.NewRequest(http.MethodPost, fakeKID+"/sign", nil)
+	if err != nil {
+		panic(err)
+	}
+	return runtime.NewResponseError(&http.Response{
+		Status:     "403 Forbidden",
+		StatusCode: http.StatusForbidden,
+		Header:     http.Header{},
+		Body:       io.NopCloser(strings.NewReader(body)),
+		Request:    req,
+	})
+}
+
+// byOperation indexes results by operation, failing t if one is missing
+// or repeated.
+func byOperation(t *testing.T, results []Result) map[string]Result {
+	t.Helper()
+	want := []string{OperationGet, OperationSign, OperationVerify, OperationEncrypt, OperationDecrypt, OperationWrapKey, OperationUnwrapKey}
+	if len(results) != len(want) {
+		t.Fatalf("got %d results, want %d", len(results), len(want))
+	}
+	m := make(map[string]Result, len(results))
+	for i, res := range results {
+		if res.Operation != want[i] {
+			t.Fatalf("result %d is %s, want %s", i, res.Operation, want[i])
+		}
+		m[res.Operation] = res
+	}
+	return m
+}
+
+func TestRunPasses(t *testing.T) {
+	f := &fakeClient{}
+	results := byOperation(t, New(f, Options{KeyName: "fake-key"}).Run(context.Background()))
+	for op, res := range results {
+		if !res.Success || res.Skipped || res.Err != nil {
+			t.Errorf("%s: success = %v, skipped = %v, err = %v, want a pass", op, res.Success, res.Skipped, res.Err)
+		}
+	}
+	if got := results[OperationSign].KeyVersion; got != "0123456789abcdef" {
+		t.Errorf("sign key version = %q, want %q", got, "0123456789abcdef")
+	}
+	if got := results[OperationSign].KeyID; got != fakeKID {
+		t.Errorf("sign key ID = %q, want %q", got, fakeKID)
+	}
+}
+
+func TestRunAlgorithmsForKeyType(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     *azkeys.JSONWebKey
+		opts    Options
+		want    map[string]string
+		skipped []string
+	}{
+		{
+			name: "RSA",
+			key:  &azkeys.JSONWebKey{Kty: to(azkeys.KeyTypeRSA)},
+			want: map[string]string{OperationSign: "RS256", OperationEncrypt: "RSA-OAEP-256", OperationWrapKey: "RSA-OAEP-256"},
+		},
+		{
+			name:    "EC P-384",
+			key:     &azkeys.JSONWebKey{Kty: to(azkeys.KeyTypeECHSM), Crv: to(azkeys.CurveNameP384)},
+			want:    map[string]string{OperationSign: "ES384", OperationVerify: "ES384"},
+			skipped: []string{OperationEncrypt, OperationDecrypt, OperationWrapKey, OperationUnwrapKey},
+		},
+		{
+			name:    "Ed25519",
+			key:     &azkeys.JSONWebKey{Kty: to(KeyTypeOKP), Crv: to(CurveNameEd25519)},
+			want:    map[string]string{OperationSign: "EdDSA"},
+			skipped: []string{OperationEncrypt, OperationDecrypt, OperationWrapKey, OperationUnwrapKey},
+		},
+		{
+			name:    "symmetric",
+			key:     &azkeys.JSONWebKey{Kty: to(azkeys.KeyTypeOctHSM)},
+			want:    map[string]string{OperationEncrypt: "A256GCM", OperationDecrypt: "A256GCM", OperationWrapKey: "A256KW", OperationUnwrapKey: "A256KW"},
+			skipped: []string{OperationSign, OperationVerify},
+		},
+		{
+			name: "options override the key type",
+			key:  &azkeys.JSONWebKey{Kty: to(azkeys.KeyTypeRSA)},
+			opts: Options{SignatureAlgorithm: azkeys.SignatureAlgorithmPS512, EncryptionAlgorithm: azkeys.EncryptionAlgorithmRSAOAEP},
+			want: map[string]string{OperationSign: "PS512", OperationEncrypt: "RSA-OAEP", OperationWrapKey: "RSA-OAEP-256"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &fakeClient{key: tt.key}
+			tt.opts.KeyName = "fake-key"
+			results := byOperation(t, New(f, tt.opts).Run(context.Background()))
+			for op, alg := range tt.want {
+				if got := f.algorithms[op]; got != alg {
+					t.Errorf("%s sent with %q, want %q", op, got, alg)
+				}
+			}
+			for _, op := range tt.skipped {
+				if res := results[op]; !res.Skipped || res.Err != nil {
+					t.Errorf("%s: skipped = %v, err = %v, want skipped", op, res.Skipped, res.Err)
+				}
+				if _, sent := f.algorithms[op]; sent {
+					t.Errorf("%s sent, but the key cannot perform it", op)
+				}
+			}
+			for op, res := range results {
+				if !res.Skipped && !res.Success {
+					t.Errorf("%s: failed with %v, want a pass", op, res.Err)
+				}
+			}
+		})
+	}
+}
+
+func TestRunGetForbidden(t *testing.T) {
+	f := &fakeClient{errs: map[string]error{OperationGet: forbidden()}}
+	results := byOperation(t, New(f, Options{KeyName: "fake-key"}).Run(context.Background()))
+	get := results[OperationGet]
+	if get.Success || !get.Forbidden() {
+		t.Errorf("get: success = %v, forbidden = %v, want a denial", get.Success, get.Forbidden())
+	}
+	if get.StatusCode != http.StatusForbidden || get.ErrorCode != "Forbidden" {
+		t.Errorf("get: status = %d, code = %q, want 403 Forbidden", get.StatusCode, get.ErrorCode)
+	}
+	// Without the key type the RSA defaults are used
+	if got := f.algorithms[OperationSign]; got != "RS256" {
+		t.Errorf("sign sent with %q, want RS256", got)
+	}
+	if !results[OperationSign].Success {
+		t.Errorf("sign failed with %v after GET was denied, want a pass", results[OperationSign].Err)
+	}
+}
+
+func TestRunSkipsAfterFailure(t *testing.T) {
+	f := &fakeClient{errs: map[string]error{
+		OperationSign:    forbidden(),
+		OperationEncrypt: forbidden(),
+		OperationWrapKey: forbidden(),
+	}}
+	results := byOperation(t, New(f, Options{KeyName: "fake-key"}).Run(context.Background()))
+	for _, op := range []string{OperationSign, OperationEncrypt, OperationWrapKey} {
+		if res := results[op]; !res.Forbidden() || res.Skipped {
+			t.Errorf("%s: forbidden = %v, skipped = %v, want a denial", op, res.Forbidden(), res.Skipped)
+		}
+	}
+	for _, op := range []string{OperationVerify, OperationDecrypt, OperationUnwrapKey} {
+		res := results[op]
+		if !res.Skipped || res.Success || res.Err != nil {
+			t.Errorf("%s: skipped = %v, success = %v, err = %v, want skipped", op, res.Skipped, res.Success, res.Err)
+		}
+		if _, sent := f.algorithms[op]; sent {
+			t.Errorf("%s sent without its input", op)
+		}
+	}
+}
+
+func TestRunMismatch(t *testing.T) {
+	for _, op := range []string{OperationVerify, OperationDecrypt, OperationUnwrapKey} {
+		t.Run(op, func(t *testing.T) {
+			f := &fakeClient{wrong: map[string]bool{op: true}}
+			results := byOperation(t, New(f, Options{KeyName: "fake-key"}).Run(context.Background()))
+			res := results[op]
+			if res.Success || !errors.Is(res.Err, ErrMismatch) {
+				t.Errorf("success = %v, err = %v, want ErrMismatch", res.Success, res.Err)
+			}
+			if res.Forbidden() {
+				t.Error("a mismatch is reported as forbidden")
+			}
+		})
+	}
+}
+
+func TestRunStopsWhenContextDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	results := New(&fakeClient{}, Options{KeyName: "fake-key"}).Run(ctx)
+	if len(results) != 1 || results[0].Operation != OperationGet {
+		t.Errorf("got %d results, want only GET once the context is done", len(results))
+	}
+}
+
+func TestDigest(t *testing.T) {
+	tests := []struct {
+		alg  azkeys.SignatureAlgorithm
+		size int
+	}{
+		{azkeys.SignatureAlgorithmRS256, 32},
+		{azkeys.SignatureAlgorithmES256K, 32},
+		{azkeys.SignatureAlgorithmPS384, 48},
+		{azkeys.SignatureAlgorithmES512, 64},
+		{SignatureAlgorithmEdDSA, 64},
+	}
+	for _, tt := range tests {
+		digest, err := Digest(tt.alg, []byte("data"))
+		if err != nil || len(digest) != tt.size {
+			t.Errorf("Digest(%s) = %d bytes, %v, want %d bytes", tt.alg, len(digest), err, tt.size)
+		}
+	}
+	if _, err := Digest("HS256", []byte("data")); err == nil {
+		t.Error("Digest(HS256) succeeded, want an error for an unknown algorithm")
+	}
+}
+
+func TestAESModes(t *testing.T) {
+	tests := []struct {
+		alg      azkeys.EncryptionAlgorithm
+		gcm, cbc bool
+	}{
+		{azkeys.EncryptionAlgorithmA256GCM, true, false},
+		{azkeys.EncryptionAlgorithmA128CBC, false, true},
+		{azkeys.EncryptionAlgorithmA256CBCPAD, false, true},
+		{azkeys.EncryptionAlgorithmA256KW, false, false},
+		{azkeys.EncryptionAlgorithmRSAOAEP256, false, false},
+	}
+	for _, tt := range tests {
+		if gcm, cbc := IsGCM(tt.alg), IsCBC(tt.alg); gcm != tt.gcm || cbc != tt.cbc {
+			t.Errorf("IsGCM(%s), IsCBC(%s) = %v, %v, want %v, %v", tt.alg, tt.alg, gcm, cbc, tt.gcm, tt.cbc)
+		}
+	}
+}
+
+func TestRunUnknownAlgorithm(t *testing.T) {
+	f := &fakeClient{}
+	results := byOperation(t, New(f, Options{KeyName: "fake-key", SignatureAlgorithm: "HS256"}).Run(context.Background()))
+	if res := results[OperationSign]; res.Success || res.Err == nil {
+		t.Errorf("sign: success = %v, err = %v, want a failure", res.Success, res.Err)
+	}
+	if _, sent := f.algorithms[OperationSign]; sent {
+		t.Error("sign sent with an algorithm that has no digest")
+	}
+	if !results[OperationVerify].Skipped {
+		t.Error("verify not skipped after sign failed")
+	}
+}
+
+func TestForbidden(t *testing.T) {
+	var res Result
+	res.setErr(forbidden())
+	if !res.Forbidden() {
+		t.Error("a 403 is not reported as forbidden")
+	}
+	res = Result{}
+	res.setErr(errors.New("connection refused"))
+	if res.Forbidden() || res.StatusCode != 0 {
+		t.Errorf("an error without a response: forbidden = %v, status = %d", res.Forbidden(), res.StatusCode)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+// preflight checks for -preflight that the credential can get a token for
+// vault and that the vault answers an authenticated request, so that a wrong
+// vault name, a blocked network, or a broken credential stops the run with one
+// clear error rather than a failure for every test. It requests a token, then
+// reads the first page of the vault's keys. A 403 from that read passes: the
+// caller reached and authenticated to the vault, and whether it may list keys
+// is for the tests to report. Each step is bounded by timeout.
+//
+// It also returns the vault's recovery settings, from the first key listed,
+// or a note explaining why they could not be read.
+func preflight(ctx context.Context, cred azcore.TokenCredential, client keyVaultClient, env cloudEnvironment, vault vaultTarget, timeout time.Duration) (recoverySettings, string, error) {
+	tokenCtx, cancel := context.WithTimeout(ctx, timeout)
+	_, err := cred.GetToken(tokenCtx, policy.TokenRequestOptions{Scopes: []string{tokenScope(env, vault.managedHSM)}})
+	cancel()
+	if err != nil {
+		return recoverySettings{}, "", fmt.Errorf("cannot authenticate to vault %s: failed to acquire token: %w", vault.url, err)
+	}
+
+	listCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	page, err := client.NewListKeyPropertiesPager(nil).NextPage(listCtx)
+	if err == nil {
+		for _, key := range page.Value {
+			if r := recoveryFromAttributes(key.Attributes); r.known() {
+				return r, "", nil
+			}
+		}
+		return recoverySettings{}, "the first page of keys listed reports no recovery level", nil
+	}
+	var respErr *azcore.ResponseError
+	if errors.As(err, &respErr) && respErr.StatusCode == http.StatusForbidden {
+		return recoverySettings{}, "listing keys is not permitted", nil
+	}
+	c := classifyError(err)
+	if c.category == categoryUnauthorized {
+		return recoverySettings{}, "", fmt.Errorf("cannot authenticate to vault %s: %s: %s", vault.url, c.label(), c.message)
+	}
+	return recoverySettings{}, "", fmt.Errorf("cannot reach vault %s: %s: %s", vault.url, c.label(), c.message)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+)
+
+// probeTimeout replaces the -timeout default for -probe, so that a probe
+// answers well within a readiness probe's own timeout.
+const probeTimeout = 5 * time.Second
+
+// runProbe signs with keyName, and verifies the signature locally when
+// cfg.localVerify is set, for -probe. Unless quiet, it prints one status line
+// to w. It returns the exit status: 0 when every step succeeded or was
+// skipped by -dry-run, and 1 otherwise.
+func runProbe(ctx context.Context, client keyVaultClient, keyName string, cfg testConfig, w io.Writer, quiet bool) int {
+	if cfg.autoAlgorithm {
+		cfg.sigAlgorithm, _ = resolveAlgorithm(ctx, client, keyName, cfg)
+	}
+	probeCfg := testConfig{
+		sign:         true,
+		localVerify:  cfg.localVerify,
+		timeout:      cfg.timeout,
+		maxRetries:   cfg.maxRetries,
+		repeat:       1,
+		keyVersion:   cfg.keyVersion,
+		sigAlgorithm: cfg.sigAlgorithm,
+		payload:      cfg.payload,
+	}
+
+	var results []testResult
+	tester{client: client, keyName: keyName, cfg: probeCfg}.runSignatureTests(ctx, func(res testResult) bool {
+		results = append(results, res)
+		return res.Success
+	})
+
+	var total time.Duration
+	for _, res := range results {
+		total += res.Duration
+		if res.Success {
+			continue
+		}
+		if !quiet {
+			if res.Skipped {
+				fmt.Fprintf(w, "SKIPPED: %s %s: %s\n", keyName, operationLabels[res.Operation], res.Notes[len(res.Notes)-1])
+			} else {
+				fmt.Fprintf(w, "FAIL: %s %s failed%s: %s\n", keyName, operationLabels[res.Operation], failureTag(res), *res.Error)
+			}
+		}
+		switch {
+		case ctx.Err() != nil:
+			return exitInterrupted
+		case res.Skipped:
+			// Only -dry-run skips a probe step
+			return 0
+		}
+		return 1
+	}
+
+	if !quiet {
+		steps := "signed"
+		if cfg.localVerify {
+			steps = "signed and verified locally"
+		}
+		fmt.Fprintf(w, "OK: %s %s with %s in %.0f ms\n", keyName, steps, cfg.sigAlgorithm, milliseconds(total))
+	}
+	return 0
+}
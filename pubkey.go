@@ -0,0 +1,75 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azkeys"
+)
+
+// Formats of the public key written by -export-pubkey.
+const (
+	pubkeyFormatPEM = "pem"
+	pubkeyFormatDER = "der"
+)
+
+// parsePubkeyFormat validates a -pubkey-format value.
+func parsePubkeyFormat(name string) (string, error) {
+	switch format := strings.ToLower(name); format {
+	case pubkeyFormatPEM, pubkeyFormatDER:
+		return format, nil
+	}
+	return "", fmt.Errorf("unknown public key format %q (expected pem or der)", name)
+}
+
+// encodePublicKey reconstructs the public key of an asymmetric JWK and
+// encodes it as a PKIX SubjectPublicKeyInfo, in DER or as a PEM "PUBLIC KEY"
+// block.
+func encodePublicKey(jwk *azkeys.JSONWebKey, format string) ([]byte, error) {
+	if jwk != nil && jwk.Kty != nil && isOctKeyType(string(*jwk.Kty)) {
+		return nil, fmt.Errorf("%s is a symmetric key and has no public key", *jwk.Kty)
+	}
+	pub, err := publicKeyFromJWK(jwk)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return nil, err
+	}
+	if format == pubkeyFormatDER {
+		return der, nil
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), nil
+}
+
+// exportPublicKey writes the public key of the key read by GET to
+// -export-pubkey, if set: a file, or stdout for "-". The outcome is noted on
+// res; a key that cannot be exported is a warning, since GET itself passed.
+func exportPublicKey(cfg testConfig, res *testResult, jwk *azkeys.JSONWebKey) {
+	if cfg.pubkeyOut == "" {
+		return
+	}
+	data, err := encodePublicKey(jwk, cfg.pubkeyFormat)
+	if err != nil {
+		res.Warnings = append(res.Warnings, fmt.Sprintf("Public key not exported: %v", err))
+		return
+	}
+	if cfg.pubkeyOut == "-" {
+		_, err = os.Stdout.Write(data)
+	} else {
+		err = os.WriteFile(cfg.pubkeyOut, data, 0o644)
+	}
+	if err != nil {
+		res.Warnings = append(res.Warnings, fmt.Sprintf("Public key not exported: %v", err))
+		return
+	}
+	if cfg.pubkeyOut == "-" {
+		res.Notes = append(res.Notes, fmt.Sprintf("Public key written to stdout (%s)", strings.ToUpper(cfg.pubkeyFormat)))
+	} else {
+		res.Notes = append(res.Notes, fmt.Sprintf("Public key written to %s (%s)", cfg.pubkeyOut, strings.ToUpper(cfg.pubkeyFormat)))
+	}
+}
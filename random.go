@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azkeys"
+)
+
+// maxRandomBytes is the most random bytes Managed HSM returns per request.
+const maxRandomBytes = 128
+
+// testRandomBytes asks the Managed HSM for cfg.randomCount bytes from its
+// RNG, which takes its own permission. Key Vault vaults have no RNG endpoint,
+// so there the test is not applicable and nothing is sent.
+func testRandomBytes(ctx context.Context, client keyVaultClient, cfg testConfig) testResult {
+	res := testResult{Operation: opRandomBytes}
+	if !cfg.managedHSM {
+		res.notApplicable("Not applicable: random bytes are only available from a Managed HSM")
+		return res
+	}
+
+	var random []byte
+	err := cfg.timed(ctx, &res, func(ctx context.Context) (err error) {
+		random, err = doTestGetRandomBytes(ctx, client, cfg.randomCount)
+		return err
+	})
+	if err != nil {
+		res.fail(err)
+		return res
+	}
+
+	res.Success = true
+	count := len(random)
+	res.RandomByteCount = &count
+	if count != cfg.randomCount {
+		res.Warnings = append(res.Warnings, fmt.Sprintf("Requested %d random bytes but received %d", cfg.randomCount, count))
+	}
+	if cfg.verbose {
+		res.RandomBytes = hex.EncodeToString(random)
+	}
+	return res
+}
+
+func doTestGetRandomBytes(ctx context.Context, client keyVaultClient, count int) ([]byte, error) {
+	n := int32(count)
+	resp, err := client.GetRandomBytes(ctx, azkeys.GetRandomBytesParameters{Count: &n}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("get random bytes operation failed: %w", err)
+	}
+	return resp.Value, nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azkeys"
+)
+
+// recoverySettings is what a key's recovery level reveals about its vault's
+// soft-delete and purge protection settings, which the data plane offers no
+// other way to read. Every key in a vault carries the vault's settings.
+type recoverySettings struct {
+	// level is the key's recoveryLevel attribute, e.g.
+	// "Recoverable+Purgeable"; empty when Key Vault did not return it.
+	level string
+
+	// days is how many days a deleted key can be recovered, or nil.
+	days *int
+}
+
+// recoveryFromAttributes reads the recovery settings of a key's attributes,
+// which may be nil.
+func recoveryFromAttributes(attrs *azkeys.KeyAttributes) recoverySettings {
+	var r recoverySettings
+	if attrs == nil {
+		return r
+	}
+	if attrs.RecoveryLevel != nil {
+		r.level = *attrs.RecoveryLevel
+	}
+	if attrs.RecoverableDays != nil {
+		days := int(*attrs.RecoverableDays)
+		r.days = &days
+	}
+	return r
+}
+
+// known reports whether Key Vault returned the recovery level.
+func (r recoverySettings) known() bool {
+	return r.level != ""
+}
+
+// softDelete reports whether deleted keys can be recovered: every level but
+// Purgeable.
+func (r recoverySettings) softDelete() bool {
+	return r.level != "Purgeable"
+}
+
+// purgeProtection reports whether deleted keys cannot be purged before their
+// retention ends; the levels that allow purging say +Purgeable.
+func (r recoverySettings) purgeProtection() bool {
+	return !strings.Contains(r.level, "Purgeable")
+}
+
+// describe renders the settings for messages, e.g. "soft-delete enabled
+// (90 days), purge protection disabled (recovery level
+// Recoverable+Purgeable)".
+func (r recoverySettings) describe() string {
+	softDelete := "soft-delete disabled"
+	if r.softDelete() {
+		softDelete = "soft-delete enabled"
+		if r.days != nil {
+			softDelete += fmt.Sprintf(" (%d days)", *r.days)
+		}
+	}
+	purgeProtection := "purge protection disabled"
+	if r.purgeProtection() {
+		purgeProtection = "purge protection enabled"
+	}
+	return fmt.Sprintf("%s, %s (recovery level %s)", softDelete, purgeProtection, r.level)
+}
+
+// record sets the recovery fields of a GET result, or notes that Key Vault
+// did not return them.
+func (r recoverySettings) record(res *testResult) {
+	if !r.known() {
+		res.Notes = append(res.Notes, "Key Vault did not return the key's recovery level, so the vault's soft-delete and purge protection settings are unknown")
+		return
+	}
+	softDelete, purgeProtection := r.softDelete(), r.purgeProtection()
+	res.RecoveryLevel = r.level
+	res.RecoverableDays = r.days
+	res.SoftDelete = &softDelete
+	res.PurgeProtection = &purgeProtection
+}
@@ -0,0 +1,1184 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"azkeyvault-perm-tester/pkg/permtest"
+)
+
+// Operation names as they appear in structured output. The key operations
+// the permtest package performs take its names.
+const (
+	opSign        = permtest.OperationSign
+	opVerify      = permtest.OperationVerify
+	opLocalVerify = "localVerify"
+	opGet         = permtest.OperationGet
+	opEncrypt     = permtest.OperationEncrypt
+	opDecrypt     = permtest.OperationDecrypt
+	opWrapKey     = permtest.OperationWrapKey
+	opUnwrapKey   = permtest.OperationUnwrapKey
+	opList        = "list"
+	opSecretGet   = "secretGet"
+	opSecretSet   = "secretSet"
+	opCertGet     = "certGet"
+	opCreate      = "create"
+	opDelete      = "delete"
+	opPurge       = "purge"
+	opImport      = "import"
+	opRotate      = "rotate"
+	opGetDeleted  = "getDeleted"
+	opRecover     = "recover"
+	opRoundTrip   = "roundTrip"
+	opUpdate      = "update"
+	opKeyPolicy   = "keyPolicy"
+
+	opNegativeVerify = "negativeVerify"
+
+	opRotationPolicyGet = "rotationPolicyGet"
+	opRotationPolicySet = "rotationPolicySet"
+	opBackup            = "backup"
+	opRestore           = "restore"
+	opRandomBytes       = "getRandomBytes"
+	opPreflight         = "preflight"
+
+	opRotationPolicyCheck = "rotationPolicyCheck"
+)
+
+// operationLabels maps operation names to the labels used in text output.
+var operationLabels = map[string]string{
+	opSign:        "SIGN",
+	opVerify:      "VERIFY",
+	opLocalVerify: "LOCAL VERIFY",
+	opGet:         "GET",
+	opEncrypt:     "ENCRYPT",
+	opDecrypt:     "DECRYPT",
+	opWrapKey:     "WRAP KEY",
+	opUnwrapKey:   "UNWRAP KEY",
+	opList:        "LIST",
+	opSecretGet:   "SECRET GET",
+	opSecretSet:   "SECRET SET",
+	opCertGet:     "CERT GET",
+	opCreate:      "CREATE",
+	opDelete:      "DELETE",
+	opPurge:       "PURGE",
+	opImport:      "IMPORT",
+	opRotate:      "ROTATE",
+	opGetDeleted:  "GET DELETED",
+	opRecover:     "RECOVER",
+	opRoundTrip:   "ROUND TRIP",
+	opUpdate:      "UPDATE",
+	opKeyPolicy:   "KEY POLICY",
+
+	opNegativeVerify: "NEGATIVE VERIFY",
+
+	opRotationPolicyGet: "GET ROTATION POLICY",
+	opRotationPolicySet: "SET ROTATION POLICY",
+	opBackup:            "BACKUP",
+	opRestore:           "RESTORE",
+	opRandomBytes:       "GET RANDOM BYTES",
+	opPreflight:         "PRE-FLIGHT",
+
+	opRotationPolicyCheck: "ROTATION POLICY",
+}
+
+// operationTitle returns the heading printed before a result in text output.
+func operationTitle(op string) string {
+	switch op {
+	case opGet:
+		return "Testing GET permission (key info retrieval)..."
+	case opLocalVerify:
+		return "Verifying signature locally with the key's public key..."
+	case opList:
+		return "Testing LIST permission (vault-wide)..."
+	case opRandomBytes:
+		return "Testing GET RANDOM BYTES permission (Managed HSM)..."
+	case opPreflight:
+		return "Checking that the vault can be reached and authenticated to..."
+	case opCreate:
+		return "Testing CREATE permission (temporary key)..."
+	case opDelete:
+		return "Testing DELETE permission (temporary key)..."
+	case opPurge:
+		return "Testing PURGE permission (temporary key, irreversible)..."
+	case opImport:
+		return "Testing IMPORT permission (temporary key)..."
+	case opRotate:
+		return "Testing ROTATE permission (temporary key)..."
+	case opUpdate:
+		return "Testing UPDATE permission (temporary key)..."
+	case opGetDeleted:
+		return "Testing GET DELETED permission (temporary key)..."
+	case opRecover:
+		return "Testing RECOVER permission (temporary key)..."
+	case opRotationPolicySet:
+		return "Testing SET ROTATION POLICY permission (temporary key)..."
+	case opRoundTrip:
+		return "Testing SIGN and VERIFY permissions as a round trip..."
+	case opNegativeVerify:
+		return "Testing that VERIFY rejects the signature for a tampered digest..."
+	case opRotationPolicyCheck:
+		return "Checking the rotation policy against -expect-rotation-within..."
+	case opKeyPolicy:
+		return "Checking the key against -min-rsa-bits and -allowed-curves..."
+	}
+	return fmt.Sprintf("Testing %s permission...", operationLabels[op])
+}
+
+// testResult is the outcome of a single permission test.
+type testResult struct {
+	Operation string `json:"operation"`
+
+	// Algorithm is set on sign and verify results when -test-all-algorithms
+	// runs them once per signature algorithm.
+	Algorithm string `json:"algorithm,omitempty"`
+
+	Success bool    `json:"success"`
+	Error   *string `json:"error"`
+	Skipped bool    `json:"skipped,omitempty"`
+
+	// NotApplicable marks a skipped test that cannot apply to the key, such
+	// as an ECDSA algorithm with an RSA key.
+	NotApplicable bool `json:"notApplicable,omitempty"`
+
+	// CachedPass is when the test last passed, for a test -skip-recent did
+	// not rerun because it passed within the window. It counts as passed.
+	CachedPass *time.Time `json:"cachedPass,omitempty"`
+
+	// DeadlineExceeded marks a skipped test that -deadline stopped before it
+	// completed.
+	DeadlineExceeded bool `json:"deadlineExceeded,omitempty"`
+
+	// ErrorCategory, StatusCode, and ErrorCode classify a failed operation
+	// by the HTTP response Key Vault returned, if any.
+	ErrorCategory string `json:"errorCategory,omitempty"`
+	StatusCode    int    `json:"statusCode,omitempty"`
+	ErrorCode     string `json:"errorCode,omitempty"`
+
+	// AuthorizationModel is the model inferred to have denied a 403 (rbac,
+	// accessPolicy, or firewall), and Remediation suggests how to fix it.
+	AuthorizationModel string `json:"authorizationModel,omitempty"`
+	Remediation        string `json:"remediation,omitempty"`
+
+	// RequestID and ClientRequestID identify a failed request to Azure
+	// support: the x-ms-request-id Key Vault assigned it and the
+	// x-ms-client-request-id it was sent with.
+	RequestID       string `json:"requestId,omitempty"`
+	ClientRequestID string `json:"clientRequestId,omitempty"`
+
+	// FailedStage is the stage of a -roundtrip result that failed: sign,
+	// verify, or check when the signature itself did not verify.
+	FailedStage string `json:"failedStage,omitempty"`
+
+	// Mismatch is set when the operation was permitted but a round-trip
+	// produced different bytes than the original input.
+	Mismatch bool `json:"mismatch,omitempty"`
+
+	// Retries counts the retries consumed by throttled or server errors.
+	Retries int `json:"retries,omitempty"`
+
+	// SigningKeyID is the full identifier, including the version, of the
+	// key that produced a signature.
+	SigningKeyID string `json:"signingKeyId,omitempty"`
+
+	Signature    string `json:"signature,omitempty"`
+	Ciphertext   string `json:"ciphertext,omitempty"`
+	WrappedKey   string `json:"wrappedKey,omitempty"`
+	KeyID        string `json:"keyId,omitempty"`
+	KeyVersion   string `json:"keyVersion,omitempty"`
+	KeyType      string `json:"keyType,omitempty"`
+	Curve        string `json:"curve,omitempty"`
+	KeySize      *int   `json:"keySize,omitempty"`
+	HSMProtected *bool  `json:"hsmProtected,omitempty"`
+
+	// IV and AuthenticationTag are returned by the AES encrypt test and
+	// passed back to decrypt.
+	IV                string `json:"iv,omitempty"`
+	AuthenticationTag string `json:"authenticationTag,omitempty"`
+
+	// KeyOps lists the operations the key itself permits.
+	KeyOps []string `json:"keyOps,omitempty"`
+
+	// Enabled and NotBefore are key attributes reported by GET, which also
+	// sets Expires.
+	Enabled   *bool      `json:"enabled,omitempty"`
+	NotBefore *time.Time `json:"notBefore,omitempty"`
+
+	// Tags and Managed are reported by GET. Managed is true for a key whose
+	// lifetime Key Vault manages, such as the key backing a certificate.
+	Tags    map[string]string `json:"tags,omitempty"`
+	Managed *bool             `json:"managed,omitempty"`
+
+	// RecoveryLevel and RecoverableDays are the key's recovery attributes,
+	// reported by GET, from which SoftDelete and PurgeProtection give the
+	// vault's settings.
+	RecoveryLevel   string `json:"recoveryLevel,omitempty"`
+	RecoverableDays *int   `json:"recoverableDays,omitempty"`
+	SoftDelete      *bool  `json:"softDeleteEnabled,omitempty"`
+	PurgeProtection *bool  `json:"purgeProtectionEnabled,omitempty"`
+
+	// ProtectionLevel is how the key is protected: managed-hsm, hsm,
+	// software, or unknown when GET did not determine it. GET reports it
+	// with HSMProtected; every other per-key result carries it too.
+	ProtectionLevel string `json:"protectionLevel,omitempty"`
+
+	BackupSize *int `json:"backupSize,omitempty"`
+
+	// DeletedDate and ScheduledPurgeDate describe a soft-deleted key.
+	DeletedDate        *time.Time `json:"deletedDate,omitempty"`
+	ScheduledPurgeDate *time.Time `json:"scheduledPurgeDate,omitempty"`
+
+	// RotationPolicy describes the key's rotation policy, one lifetime
+	// action per entry.
+	RotationPolicy []string `json:"rotationPolicy,omitempty"`
+
+	// RotationCompliance is the outcome of -expect-rotation-within:
+	// compliant, noPolicy, noAutoRotation, or tooLax. RotationTrigger is the
+	// trigger of the policy's most frequent Rotate action, e.g. "P90D after
+	// creation", and RotationExpiryTime the expiry the policy gives new
+	// versions, e.g. "P2Y".
+	RotationCompliance string `json:"rotationCompliance,omitempty"`
+	RotationTrigger    string `json:"rotationTrigger,omitempty"`
+	RotationExpiryTime string `json:"rotationExpiryTime,omitempty"`
+
+	// RandomByteCount is how many bytes the Managed HSM's RNG returned, and
+	// RandomBytes the bytes in hex, with -verbose.
+	RandomByteCount *int   `json:"randomByteCount,omitempty"`
+	RandomBytes     string `json:"randomBytes,omitempty"`
+
+	KeyCount *int     `json:"keyCount,omitempty"`
+	KeyNames []string `json:"keyNames,omitempty"`
+
+	SecretID          string `json:"secretId,omitempty"`
+	SecretValueLength *int   `json:"secretValueLength,omitempty"`
+	SecretValue       string `json:"secretValue,omitempty"`
+
+	CertificateID string     `json:"certificateId,omitempty"`
+	Thumbprint    string     `json:"thumbprint,omitempty"`
+	Subject       string     `json:"subject,omitempty"`
+	Expires       *time.Time `json:"expires,omitempty"`
+
+	Notes    []string `json:"notes,omitempty"`
+	Warnings []string `json:"warnings,omitempty"`
+
+	// Duration is the wall-clock time of the Key Vault call, or zero when
+	// the test was skipped without making one. With -repeat it covers every
+	// run, and Latency breaks it down.
+	Duration   time.Duration `json:"-"`
+	DurationMs float64       `json:"durationMs,omitempty"`
+	Latency    *latencyStats `json:"latency,omitempty"`
+}
+
+func (r *testResult) fail(err error) {
+	var dryRun *dryRunError
+	if errors.As(err, &dryRun) {
+		r.skip("Dry run: " + dryRun.describe())
+		return
+	}
+	var unsupported *permtest.UnsupportedAlgorithmError
+	if errors.As(err, &unsupported) {
+		r.notApplicable(fmt.Sprintf("Not applicable: the vault rejected %s, which it may not support yet: %s", unsupported.Algorithm, classifyError(unsupported.Err).message))
+		return
+	}
+	if errors.Is(err, context.Canceled) {
+		// Only an interrupt cancels the run's context; timeouts are
+		// reported as failures
+		r.skip("Interrupted before the operation completed")
+		return
+	}
+
+	c := classifyError(err)
+	r.Success = false
+	r.Error = &c.message
+	r.ErrorCategory = c.category
+	r.StatusCode = c.statusCode
+	r.ErrorCode = c.errorCode
+	r.AuthorizationModel = c.authModel
+	r.RequestID = c.requestID
+	r.ClientRequestID = c.clientRequestID
+	r.Remediation = remediation(r.Operation, c.authModel)
+}
+
+// checkFailed records a failure the tool found itself rather than one Key
+// Vault reported, such as a key that cannot be used or is too weak. category
+// keeps it apart from permission failures.
+func (r *testResult) checkFailed(category, msg string) {
+	r.Success = false
+	r.Error = &msg
+	r.ErrorCategory = category
+}
+
+// notApplicable records a test that was not sent because it cannot apply
+// to the key, such as a signature algorithm for another key type. It counts
+// as skipped.
+func (r *testResult) notApplicable(note string) {
+	r.NotApplicable = true
+	r.skip(note)
+}
+
+// resultStatus names the outcome of res in CSV output and the algorithm
+// matrix: passed, cachedPass, failed, mismatch, skipped, skippedDeadline, or
+// notApplicable.
+func resultStatus(res testResult) string {
+	switch {
+	case res.NotApplicable:
+		return "notApplicable"
+	case res.CachedPass != nil:
+		return "cachedPass"
+	case res.DeadlineExceeded:
+		return "skippedDeadline"
+	case res.Skipped:
+		return "skipped"
+	case res.Mismatch:
+		return "mismatch"
+	case res.Success:
+		return "passed"
+	}
+	return "failed"
+}
+
+// isFailure reports whether res is a failure: an operation that was attempted
+// and either denied or produced a mismatch.
+func isFailure(res testResult) bool {
+	return !res.Success && !res.Skipped
+}
+
+func (r *testResult) skip(note string) {
+	r.Skipped = true
+	r.Notes = append(r.Notes, note)
+}
+
+// mismatch records an operation that Key Vault permitted but whose result
+// was wrong. It is categorized apart from permission failures.
+func (r *testResult) mismatch(msg string) {
+	r.Success = false
+	r.Mismatch = true
+	r.Error = &msg
+	r.ErrorCategory = categoryMismatch
+}
+
+// checkRoundTrip records a round-trip mismatch unless got, what an operation
+// recovered, equals want, the original. A 200 from Key Vault only proves the
+// permission; the bytes must match for the round trip to pass.
+func (r *testResult) checkRoundTrip(what string, got, want []byte) {
+	if bytes.Equal(got, want) {
+		r.Success = true
+		return
+	}
+	if len(got) != len(want) {
+		r.mismatch(fmt.Sprintf("round-trip mismatch: %s is %d bytes, but the original is %d bytes", what, len(got), len(want)))
+		return
+	}
+	i := 0
+	for got[i] == want[i] {
+		i++
+	}
+	r.mismatch(fmt.Sprintf("round-trip mismatch: %s differs from the original at byte %d of %d", what, i, len(want)))
+}
+
+// runReport is the aggregate outcome of testing a single key. Vault-wide,
+// secret, and certificate tests are reported separately with an empty
+// KeyName.
+type runReport struct {
+	VaultURL        string `json:"vaultUrl"`
+	KeyName         string `json:"keyName,omitempty"`
+	KeyVersion      string `json:"keyVersion,omitempty"`
+	SecretName      string `json:"secretName,omitempty"`
+	CertificateName string `json:"certificateName,omitempty"`
+	Algorithm       string `json:"algorithm,omitempty"`
+	AlgorithmSource string `json:"algorithmSource,omitempty"`
+	AllAlgorithms   bool   `json:"allAlgorithms,omitempty"`
+
+	// AlgorithmMatrix aggregates the results of -test-all-algorithms by
+	// algorithm and operation. It is set by jsonReporter and covers every
+	// result, even with -quiet.
+	AlgorithmMatrix map[string]map[string]string `json:"algorithmMatrix,omitempty"`
+
+	EncryptionAlgorithm string       `json:"encryptionAlgorithm,omitempty"`
+	WrapAlgorithm       string       `json:"wrapAlgorithm,omitempty"`
+	Cloud               string       `json:"cloud,omitempty"`
+	ManagedHSM          bool         `json:"managedHsm,omitempty"`
+	Results             []testResult `json:"results"`
+
+	// Aborted is set when -strict stopped the run at the first failure.
+	Aborted bool `json:"aborted,omitempty"`
+
+	// Interrupted is set when SIGINT or SIGTERM stopped the run.
+	Interrupted bool `json:"interrupted,omitempty"`
+}
+
+// runSummary counts the results of every report in a run, so that CI can
+// decide from one field whether the run passed.
+type runSummary struct {
+	Total   int `json:"total"`
+	Passed  int `json:"passed"`
+	Failed  int `json:"failed"`
+	Skipped int `json:"skipped"`
+
+	// AllPassed is set when no test failed and the run was neither aborted
+	// by -strict, interrupted, nor cut short by -deadline. Skipped tests do
+	// not count as failures.
+	AllPassed bool `json:"allPassed"`
+}
+
+// summarize counts the results of reports. Mismatches count as failures.
+func summarize(reports []*runReport) *runSummary {
+	s := &runSummary{}
+	stopped := false
+	for _, r := range reports {
+		for _, res := range r.Results {
+			s.Total++
+			switch {
+			case res.Skipped:
+				s.Skipped++
+			case res.Success:
+				s.Passed++
+			default:
+				s.Failed++
+			}
+			stopped = stopped || res.DeadlineExceeded
+		}
+		stopped = stopped || r.Aborted || r.Interrupted
+	}
+	s.AllPassed = s.Failed == 0 && !stopped
+	return s
+}
+
+// resultLineReporter prints one line counting the results once the run is
+// finished, e.g. "RESULT passed=40 failed=2 skipped=3 vaults=1 keys=10", so
+// that wrapper scripts can grep a stable line whatever the output format.
+type resultLineReporter struct {
+	w io.Writer
+}
+
+func (l *resultLineReporter) beginKey(r *runReport) {}
+
+func (l *resultLineReporter) result(res testResult) {}
+
+func (l *resultLineReporter) endKey(r *runReport) {}
+
+func (l *resultLineReporter) finish(reports []*runReport) error {
+	vaults := make(map[string]bool)
+	keys := make(map[[2]string]bool)
+	for _, r := range reports {
+		vaults[r.VaultURL] = true
+		if r.KeyName != "" {
+			keys[[2]string{r.VaultURL, r.KeyName}] = true
+		}
+	}
+	s := summarize(reports)
+	_, err := fmt.Fprintf(l.w, "RESULT passed=%d failed=%d skipped=%d vaults=%d keys=%d\n", s.Passed, s.Failed, s.Skipped, len(vaults), len(keys))
+	return err
+}
+
+// reporter renders a run as it progresses. beginKey and endKey bracket the
+// results for each tested key, and finish is called once after all keys.
+type reporter interface {
+	beginKey(r *runReport)
+	result(res testResult)
+	endKey(r *runReport)
+	finish(reports []*runReport) error
+}
+
+// reporterOptions adjust the output of newReporter's reporters.
+type reporterOptions struct {
+	// quiet limits text, JSON, and CSV output to failures, and text to a
+	// final pass count.
+	quiet bool
+
+	// showVault labels text rows with their vault, for runs over several
+	// vaults.
+	showVault bool
+
+	// plain replaces the emoji of text output with ASCII markers.
+	plain bool
+
+	// pretty indents JSON output.
+	pretty bool
+
+	// template is the text of the Go template for template output.
+	template string
+}
+
+// newReporter returns the reporter for format.
+func newReporter(format string, w io.Writer, opts reporterOptions) (reporter, error) {
+	switch format {
+	case "text":
+		markers := emojiMarkers
+		if opts.plain {
+			markers = plainMarkers
+		}
+		return &textReporter{w: w, quiet: opts.quiet, showVault: opts.showVault, markers: markers}, nil
+	case "json":
+		return &jsonReporter{w: w, failuresOnly: opts.quiet, pretty: opts.pretty}, nil
+	case "jsonl":
+		return &jsonlReporter{w: w, failuresOnly: opts.quiet}, nil
+	case "junit":
+		return &junitReporter{w: w}, nil
+	case "sarif":
+		return &sarifReporter{w: w}, nil
+	case "csv":
+		return newCSVReporter(w, opts.quiet), nil
+	case "template":
+		if opts.template == "" {
+			return nil, fmt.Errorf("-output template needs a template; pass it with -template")
+		}
+		markers := emojiMarkers
+		if opts.plain {
+			markers = plainMarkers
+		}
+		return newTemplateReporter(w, opts.template, opts.quiet, markers)
+	default:
+		return nil, fmt.Errorf("unknown output format %q (expected text, json, jsonl, junit, sarif, csv, or template)", format)
+	}
+}
+
+// isTerminal reports whether f is a terminal rather than a pipe or a file.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// multiReporter fans a run out to several reporters, such as text on stdout
+// and JUnit XML written to a file.
+type multiReporter []reporter
+
+func (m multiReporter) beginKey(r *runReport) {
+	for _, rep := range m {
+		rep.beginKey(r)
+	}
+}
+
+func (m multiReporter) result(res testResult) {
+	for _, rep := range m {
+		rep.result(res)
+	}
+}
+
+func (m multiReporter) endKey(r *runReport) {
+	for _, rep := range m {
+		rep.endKey(r)
+	}
+}
+
+func (m multiReporter) finish(reports []*runReport) error {
+	for _, rep := range m {
+		if err := rep.finish(reports); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// textReporter prints human-readable results as each test completes.
+type textReporter struct {
+	w    io.Writer
+	num  int
+	keys int
+
+	// quiet prints only failures, each prefixed with row, the summary name
+	// of the current report, and a final pass count.
+	quiet bool
+	row   string
+
+	// algorithm is the algorithm of the current -test-all-algorithms group.
+	algorithm string
+
+	// showVault labels rows with their vault, for runs over several vaults.
+	showVault bool
+
+	markers textMarkers
+}
+
+// textMarkers mark the status of results in text output. Each marker
+// includes the spacing before the text that follows it.
+type textMarkers struct {
+	passed, failed, mismatch, warning, note, hint string
+
+	// Summary table cells
+	cellPassed, cellFailed, cellMismatch, cellSkipped, cellNotApplicable string
+}
+
+var emojiMarkers = textMarkers{
+	passed:   "✅ ",
+	failed:   "❌ ",
+	mismatch: "⚠️  ",
+	warning:  "⚠️  ",
+	note:     "ℹ️  ",
+	hint:     "💡 ",
+
+	// The emoji carry a variation selector so that their rune count
+	// matches their two-column display width, which keeps tabwriter's
+	// alignment intact.
+	cellPassed:   "✅\uFE0F",
+	cellFailed:   "❌\uFE0F",
+	cellMismatch: "⚠️",
+	cellSkipped:  "⏭️",
+
+	cellNotApplicable: "N/A",
+}
+
+// plainMarkers are used with -no-color, NO_COLOR, or when stdout is not a
+// terminal, so that logs and files stay readable.
+var plainMarkers = textMarkers{
+	passed:   "[PASS] ",
+	failed:   "[FAIL] ",
+	mismatch: "[MISMATCH] ",
+	warning:  "[WARN] ",
+	note:     "[INFO] ",
+	hint:     "[HINT] ",
+
+	cellPassed:   "PASS",
+	cellFailed:   "FAIL",
+	cellMismatch: "MISMATCH",
+	cellSkipped:  "SKIP",
+
+	cellNotApplicable: "N/A",
+}
+
+func (t *textReporter) beginKey(r *runReport) {
+	t.num = 0
+	t.algorithm = ""
+	t.keys++
+	if t.quiet {
+		t.row = summaryRowName(r)
+		if t.showVault {
+			t.row = vaultName(r.VaultURL) + " " + t.row
+		}
+		return
+	}
+	if t.keys > 1 {
+		fmt.Fprintln(t.w, "========================================")
+		fmt.Fprintln(t.w)
+	}
+	if r.KeyName == "" {
+		if r.SecretName != "" {
+			fmt.Fprintf(t.w, "Testing Azure Key Vault secret permissions for secret: %s\n", r.SecretName)
+		} else if r.CertificateName != "" {
+			fmt.Fprintf(t.w, "Testing Azure Key Vault certificate permissions for certificate: %s\n", r.CertificateName)
+		} else {
+			fmt.Fprintf(t.w, "Testing Azure Key Vault vault-wide permissions\n")
+		}
+		fmt.Fprintf(t.w, "Vault URL: %s\n", r.VaultURL)
+		if r.ManagedHSM {
+			fmt.Fprintln(t.w, "Endpoint: Managed HSM")
+		}
+		if r.Cloud != "" {
+			fmt.Fprintf(t.w, "Cloud: %s\n", r.Cloud)
+		}
+		fmt.Fprintln(t.w)
+		return
+	}
+
+	fmt.Fprintf(t.w, "Testing Azure Key Vault permissions for key: %s\n", r.KeyName)
+	if r.KeyVersion != "" {
+		fmt.Fprintf(t.w, "Key Version: %s\n", r.KeyVersion)
+	}
+	fmt.Fprintf(t.w, "Vault URL: %s\n", r.VaultURL)
+	if r.AllAlgorithms {
+		fmt.Fprintln(t.w, "Algorithm: every signature algorithm, where applicable to the key")
+	} else if r.AlgorithmSource != "" {
+		fmt.Fprintf(t.w, "Algorithm: %s (%s)\n", r.Algorithm, r.AlgorithmSource)
+	} else {
+		fmt.Fprintf(t.w, "Algorithm: %s\n", r.Algorithm)
+	}
+	if r.Cloud != "" {
+		fmt.Fprintf(t.w, "Cloud: %s\n", r.Cloud)
+	}
+	if r.EncryptionAlgorithm != "" {
+		fmt.Fprintf(t.w, "Encryption Algorithm: %s\n", r.EncryptionAlgorithm)
+	}
+	if r.WrapAlgorithm != "" {
+		fmt.Fprintf(t.w, "Wrap Algorithm: %s\n", r.WrapAlgorithm)
+	}
+	if r.ManagedHSM {
+		fmt.Fprintln(t.w, "Endpoint: Managed HSM")
+		fmt.Fprintln(t.w, "Note: All keys in a Managed HSM are HSM-protected, regardless of key type")
+	} else {
+		fmt.Fprintln(t.w, "Note: HSM vs Software keys are determined by the key's protection level, not the algorithm")
+	}
+	fmt.Fprintln(t.w)
+}
+
+func (t *textReporter) result(res testResult) {
+	if t.quiet {
+		if isFailure(res) {
+			fmt.Fprintf(t.w, "%s%s: %s failed%s: %s\n", t.markers.failed, t.row, resultLabel(res), failureTag(res), *res.Error)
+			if res.Remediation != "" {
+				fmt.Fprintf(t.w, "   %s%s\n", t.markers.hint, res.Remediation)
+			}
+			for _, warning := range res.Warnings {
+				fmt.Fprintf(t.w, "   %s%s\n", t.markers.warning, warning)
+			}
+		}
+		return
+	}
+
+	if res.Algorithm != t.algorithm {
+		t.algorithm = res.Algorithm
+		if res.Algorithm != "" {
+			fmt.Fprintf(t.w, "--- Algorithm %s ---\n\n", res.Algorithm)
+		}
+	}
+	t.num++
+	label := operationLabels[res.Operation]
+	fmt.Fprintf(t.w, "%d. %s\n", t.num, operationTitle(res.Operation))
+	for _, warning := range res.Warnings {
+		fmt.Fprintf(t.w, "   %s%s\n", t.markers.warning, warning)
+	}
+
+	switch {
+	case res.Skipped:
+	case res.Mismatch:
+		fmt.Fprintf(t.w, "   %s%s permission granted%s, but %s\n", t.markers.mismatch, label, latencyTag(res), *res.Error)
+	case res.CachedPass != nil:
+		fmt.Fprintf(t.w, "   %s%s successful (cached pass)\n", t.markers.passed, label)
+	case res.Success:
+		fmt.Fprintf(t.w, "   %s%s successful%s\n", t.markers.passed, label, latencyTag(res))
+	default:
+		fmt.Fprintf(t.w, "   %s%s failed%s%s: %s\n", t.markers.failed, label, latencyTag(res), failureTag(res), *res.Error)
+		if res.Remediation != "" {
+			fmt.Fprintf(t.w, "   %s%s\n", t.markers.hint, res.Remediation)
+		}
+	}
+	if res.FailedStage != "" {
+		fmt.Fprintf(t.w, "   Failed Stage: %s\n", res.FailedStage)
+	}
+	if id := requestIDs(res); id != "" {
+		fmt.Fprintf(t.w, "   %s\n", id)
+	}
+
+	if res.Signature != "" {
+		fmt.Fprintf(t.w, "   Signature: %s\n", res.Signature)
+	}
+	if res.Ciphertext != "" {
+		fmt.Fprintf(t.w, "   Ciphertext: %s\n", res.Ciphertext)
+	}
+	if res.IV != "" {
+		fmt.Fprintf(t.w, "   IV: %s\n", res.IV)
+	}
+	if res.AuthenticationTag != "" {
+		fmt.Fprintf(t.w, "   Authentication Tag: %s\n", res.AuthenticationTag)
+	}
+	if res.WrappedKey != "" {
+		fmt.Fprintf(t.w, "   Wrapped Key: %s\n", res.WrappedKey)
+	}
+	if res.KeyID != "" {
+		fmt.Fprintf(t.w, "   Key ID: %s\n", res.KeyID)
+	}
+	if res.SigningKeyID != "" {
+		fmt.Fprintf(t.w, "   Signed By: %s\n", res.SigningKeyID)
+	} else if res.KeyVersion != "" {
+		fmt.Fprintf(t.w, "   Key Version: %s\n", res.KeyVersion)
+	}
+	if res.KeyType != "" {
+		fmt.Fprintf(t.w, "   Key Type: %s\n", res.KeyType)
+	}
+	if res.Curve != "" {
+		fmt.Fprintf(t.w, "   Curve: %s\n", res.Curve)
+	}
+	if len(res.KeyOps) > 0 {
+		fmt.Fprintf(t.w, "   Key Operations: %s\n", strings.Join(res.KeyOps, ", "))
+	}
+	if res.KeySize != nil {
+		fmt.Fprintf(t.w, "   Key Size: %d bits\n", *res.KeySize)
+	}
+	if res.Enabled != nil {
+		fmt.Fprintf(t.w, "   Enabled: %v\n", *res.Enabled)
+	}
+	if res.NotBefore != nil {
+		fmt.Fprintf(t.w, "   Not Before: %s\n", res.NotBefore.Format(time.RFC3339))
+	}
+	if res.HSMProtected != nil {
+		switch res.ProtectionLevel {
+		case protectionManagedHSM:
+			fmt.Fprintf(t.w, "   HSM Protected: %v (Managed HSM endpoint)\n", *res.HSMProtected)
+		case protectionHSM:
+			fmt.Fprintf(t.w, "   HSM Protected: %v (key type %s)\n", *res.HSMProtected, res.KeyType)
+		default:
+			fmt.Fprintf(t.w, "   HSM Protected: %v\n", *res.HSMProtected)
+		}
+	}
+	if res.Managed != nil {
+		if *res.Managed {
+			fmt.Fprintln(t.w, "   Managed: true (lifetime managed by Key Vault, e.g. a certificate's key)")
+		} else {
+			fmt.Fprintln(t.w, "   Managed: false")
+		}
+	}
+	if len(res.Tags) > 0 {
+		fmt.Fprintf(t.w, "   Tags: %s\n", formatTags(res.Tags))
+	}
+	if res.SoftDelete != nil {
+		if res.RecoverableDays != nil {
+			fmt.Fprintf(t.w, "   Soft Delete: %v (%d days)\n", *res.SoftDelete, *res.RecoverableDays)
+		} else {
+			fmt.Fprintf(t.w, "   Soft Delete: %v\n", *res.SoftDelete)
+		}
+		fmt.Fprintf(t.w, "   Purge Protection: %v (recovery level %s)\n", *res.PurgeProtection, res.RecoveryLevel)
+	}
+	if res.BackupSize != nil {
+		fmt.Fprintf(t.w, "   Backup Size: %d bytes\n", *res.BackupSize)
+	}
+	if res.DeletedDate != nil {
+		fmt.Fprintf(t.w, "   Deleted: %s\n", res.DeletedDate.Format(time.RFC3339))
+	}
+	if res.ScheduledPurgeDate != nil {
+		fmt.Fprintf(t.w, "   Scheduled Purge: %s\n", res.ScheduledPurgeDate.Format(time.RFC3339))
+	}
+	if len(res.RotationPolicy) > 0 {
+		fmt.Fprintf(t.w, "   Rotation Policy: %s\n", strings.Join(res.RotationPolicy, "; "))
+	}
+	if res.RotationCompliance != "" {
+		fmt.Fprintf(t.w, "   Rotation Compliance: %s\n", res.RotationCompliance)
+	}
+	if res.RotationTrigger != "" {
+		fmt.Fprintf(t.w, "   Rotation Trigger: %s\n", res.RotationTrigger)
+	}
+	if res.RotationExpiryTime != "" {
+		fmt.Fprintf(t.w, "   Expiry Time: %s\n", res.RotationExpiryTime)
+	}
+	if res.RandomByteCount != nil {
+		fmt.Fprintf(t.w, "   Random Bytes Received: %d\n", *res.RandomByteCount)
+	}
+	if res.RandomBytes != "" {
+		fmt.Fprintf(t.w, "   Random Bytes: %s\n", res.RandomBytes)
+	}
+	if res.KeyCount != nil {
+		fmt.Fprintf(t.w, "   Keys Found: %d\n", *res.KeyCount)
+	}
+	for _, name := range res.KeyNames {
+		fmt.Fprintf(t.w, "     - %s\n", name)
+	}
+	if res.SecretID != "" {
+		fmt.Fprintf(t.w, "   Secret ID: %s\n", res.SecretID)
+	}
+	if res.SecretValue != "" {
+		fmt.Fprintf(t.w, "   Secret Value: %s\n", res.SecretValue)
+	} else if res.SecretValueLength != nil {
+		fmt.Fprintf(t.w, "   Secret Value: retrieved (%d characters, hidden; use -show-secret to print)\n", *res.SecretValueLength)
+	}
+	if res.CertificateID != "" {
+		fmt.Fprintf(t.w, "   Certificate ID: %s\n", res.CertificateID)
+	}
+	if res.Subject != "" {
+		fmt.Fprintf(t.w, "   Subject: %s\n", res.Subject)
+	}
+	if res.Thumbprint != "" {
+		fmt.Fprintf(t.w, "   Thumbprint: %s\n", res.Thumbprint)
+	}
+	if res.Expires != nil {
+		fmt.Fprintf(t.w, "   Expires: %s\n", res.Expires.Format(time.RFC3339))
+	}
+	if l := res.Latency; l != nil && l.OpsPerSec > 0 {
+		fmt.Fprintf(t.w, "   Benchmark: %d runs after %d warmup, p50 %.1f ms, p95 %.1f ms, p99 %.1f ms, %.1f ops/sec\n", l.Runs, l.Warmup, l.P50Ms, l.P95Ms, l.P99Ms, l.OpsPerSec)
+	}
+	for _, note := range res.Notes {
+		fmt.Fprintf(t.w, "   %s%s\n", t.markers.note, note)
+	}
+	fmt.Fprintln(t.w)
+}
+
+// requestIDs renders the identifiers of a failed request for support cases,
+// e.g. "Request ID: 0f6a... (client request ID: 4b1e...)", or returns "" when
+// Key Vault did not answer.
+func requestIDs(res testResult) string {
+	switch {
+	case res.RequestID != "" && res.ClientRequestID != "":
+		return fmt.Sprintf("Request ID: %s (client request ID: %s)", res.RequestID, res.ClientRequestID)
+	case res.RequestID != "":
+		return "Request ID: " + res.RequestID
+	case res.ClientRequestID != "":
+		return "Client Request ID: " + res.ClientRequestID
+	}
+	return ""
+}
+
+// failureTag summarizes a failure's classification for text output, e.g.
+// " [Forbidden (403), error code Forbidden]". Unclassified failures have no tag.
+func failureTag(res testResult) string {
+	if res.ErrorCategory == "" || (res.ErrorCategory == categoryOther && res.StatusCode == 0) {
+		return ""
+	}
+	c := classifiedError{category: res.ErrorCategory, statusCode: res.StatusCode}
+	if res.ErrorCode != "" {
+		return fmt.Sprintf(" [%s, error code %s]", c.label(), res.ErrorCode)
+	}
+	return fmt.Sprintf(" [%s]", c.label())
+}
+
+func (t *textReporter) endKey(r *runReport) {
+	if len(r.Results) == 0 && !t.quiet {
+		fmt.Fprintln(t.w, "No tests selected. Use the -test-* flags to choose which permissions to test.")
+		fmt.Fprintln(t.w)
+	}
+	if r.AllAlgorithms && !t.quiet {
+		t.algorithmMatrix(r)
+	}
+}
+
+// algorithmMatrix prints the signature results of a -test-all-algorithms
+// report as a table of algorithms by operation.
+func (t *textReporter) algorithmMatrix(r *runReport) {
+	var algorithms, ops []string
+	cells := make(map[[2]string]string)
+	for _, res := range r.Results {
+		if res.Algorithm == "" {
+			continue
+		}
+		if !slices.Contains(algorithms, res.Algorithm) {
+			algorithms = append(algorithms, res.Algorithm)
+		}
+		if !slices.Contains(ops, res.Operation) {
+			ops = append(ops, res.Operation)
+		}
+		cells[[2]string{res.Algorithm, res.Operation}] = t.summaryCell(res)
+	}
+	if len(algorithms) == 0 {
+		return
+	}
+
+	fmt.Fprintln(t.w, "Algorithms:")
+	tw := tabwriter.NewWriter(t.w, 0, 0, 2, ' ', 0)
+	fmt.Fprint(tw, "ALGORITHM")
+	for _, op := range ops {
+		fmt.Fprintf(tw, "\t%s", operationLabels[op])
+	}
+	fmt.Fprintln(tw)
+	for _, alg := range algorithms {
+		fmt.Fprint(tw, alg)
+		for _, op := range ops {
+			cell, ok := cells[[2]string{alg, op}]
+			if !ok {
+				cell = cellNotRun
+			}
+			fmt.Fprintf(tw, "\t%s", cell)
+		}
+		fmt.Fprintln(tw)
+	}
+	tw.Flush()
+	fmt.Fprintln(t.w)
+}
+
+func (t *textReporter) finish(reports []*runReport) error {
+	interrupted, deadline := false, false
+	for _, r := range reports {
+		for _, res := range r.Results {
+			deadline = deadline || res.DeadlineExceeded
+		}
+		if r.Aborted {
+			if r.KeyName == "" {
+				fmt.Fprintln(t.w, "Stopped after the first failure on a vault-wide test (-strict).")
+			} else {
+				fmt.Fprintf(t.w, "Stopped after the first failure on key %s (-strict).\n", r.KeyName)
+			}
+		}
+		interrupted = interrupted || r.Interrupted
+	}
+	if interrupted {
+		fmt.Fprintln(t.w, "Interrupted; only the tests that completed are reported.")
+	}
+	if deadline {
+		fmt.Fprintln(t.w, "Deadline reached (-deadline); the tests that had not completed are reported as skipped.")
+	}
+	if t.quiet {
+		t.passCount(reports)
+		return nil
+	}
+	t.summary(reports)
+	if interrupted {
+		fmt.Fprintln(t.w, "Permission test interrupted.")
+	} else if deadline {
+		fmt.Fprintln(t.w, "Permission test stopped at the deadline.")
+	} else {
+		fmt.Fprintln(t.w, "Permission test completed.")
+	}
+	return nil
+}
+
+// passCount prints the one-line summary used by -quiet, e.g. "42/45 passed".
+// Skipped tests are left out of the total.
+func (t *textReporter) passCount(reports []*runReport) {
+	s := summarize(reports)
+	if s.Skipped > 0 {
+		fmt.Fprintf(t.w, "%d/%d passed, %d skipped\n", s.Passed, s.Passed+s.Failed, s.Skipped)
+	} else {
+		fmt.Fprintf(t.w, "%d/%d passed\n", s.Passed, s.Passed+s.Failed)
+	}
+}
+
+// cellNotRun marks an operation that was not run for a row of the summary
+// table.
+const cellNotRun = "-"
+
+// summary prints a permission matrix with one row per report and one column
+// per operation that was tested anywhere in the run.
+func (t *textReporter) summary(reports []*runReport) {
+	var columns []string
+	seen := make(map[string]bool)
+	for _, r := range reports {
+		for _, res := range r.Results {
+			if column := resultLabel(res); !seen[column] {
+				seen[column] = true
+				columns = append(columns, column)
+			}
+		}
+	}
+	if len(columns) == 0 {
+		return
+	}
+
+	fmt.Fprintln(t.w, "Summary:")
+	tw := tabwriter.NewWriter(t.w, 0, 0, 2, ' ', 0)
+	if t.showVault {
+		fmt.Fprint(tw, "VAULT\t")
+	}
+	fmt.Fprint(tw, "KEY")
+	for _, column := range columns {
+		fmt.Fprintf(tw, "\t%s", column)
+	}
+	fmt.Fprintln(tw)
+
+	for _, r := range reports {
+		cells := make(map[string]string)
+		for _, res := range r.Results {
+			cells[resultLabel(res)] = t.summaryCell(res)
+		}
+		if t.showVault {
+			fmt.Fprintf(tw, "%s\t", vaultName(r.VaultURL))
+		}
+		fmt.Fprint(tw, summaryRowName(r))
+		for _, column := range columns {
+			cell, ok := cells[column]
+			if !ok {
+				cell = cellNotRun
+			}
+			fmt.Fprintf(tw, "\t%s", cell)
+		}
+		fmt.Fprintln(tw)
+	}
+	tw.Flush()
+	fmt.Fprintln(t.w)
+}
+
+// resultLabel names a result in summaries, e.g. "SIGN" or, with
+// -test-all-algorithms, "SIGN PS384".
+func resultLabel(res testResult) string {
+	if res.Algorithm != "" {
+		return operationLabels[res.Operation] + " " + res.Algorithm
+	}
+	return operationLabels[res.Operation]
+}
+
+func (t *textReporter) summaryCell(res testResult) string {
+	switch {
+	case res.NotApplicable:
+		return t.markers.cellNotApplicable
+	case res.Skipped:
+		return t.markers.cellSkipped
+	case res.Mismatch:
+		return t.markers.cellMismatch
+	case res.Success:
+		return t.markers.cellPassed
+	}
+	return t.markers.cellFailed
+}
+
+// summaryRowName labels a report's row: the key name, or the secret,
+// certificate, or vault for tests that are not tied to a key.
+func summaryRowName(r *runReport) string {
+	switch {
+	case r.KeyName != "":
+		return r.KeyName
+	case r.SecretName != "":
+		return "secret " + r.SecretName
+	case r.CertificateName != "":
+		return "certificate " + r.CertificateName
+	}
+	return "(vault-wide)"
+}
+
+// jsonReporter emits the whole run once it completes, as one object holding
+// the run summary and the per-key reports.
+type jsonReporter struct {
+	w io.Writer
+
+	// failuresOnly drops passed and skipped results, for -quiet.
+	failuresOnly bool
+
+	// pretty indents the output by two spaces, for -pretty. Otherwise it is
+	// written on a single line for piping.
+	pretty bool
+}
+
+func (j *jsonReporter) beginKey(r *runReport) {}
+
+func (j *jsonReporter) result(res testResult) {}
+
+func (j *jsonReporter) endKey(r *runReport) {
+	if r.Results == nil {
+		r.Results = []testResult{}
+	}
+	if r.AllAlgorithms {
+		r.AlgorithmMatrix = algorithmMatrix(r.Results)
+	}
+}
+
+// finish writes an object holding the run summary and the reports, even
+// when there is only one, so that consumers always find .summary and
+// .reports in the same place. The summary counts every result, even with
+// -quiet.
+func (j *jsonReporter) finish(reports []*runReport) error {
+	summary := summarize(reports)
+	if j.failuresOnly {
+		reports = onlyFailures(reports)
+	}
+	if reports == nil {
+		reports = []*runReport{}
+	}
+	enc := json.NewEncoder(j.w)
+	if j.pretty {
+		enc.SetIndent("", "  ")
+	}
+	return enc.Encode(struct {
+		Summary *runSummary  `json:"summary"`
+		Reports []*runReport `json:"reports"`
+	}{summary, reports})
+}
+
+// onlyFailures returns copies of reports keeping only their failed results.
+// The originals are left intact for any other reporter.
+func onlyFailures(reports []*runReport) []*runReport {
+	filtered := make([]*runReport, len(reports))
+	for i, r := range reports {
+		c := *r
+		c.Results = []testResult{}
+		for _, res := range r.Results {
+			if isFailure(res) {
+				c.Results = append(c.Results, res)
+			}
+		}
+		filtered[i] = &c
+	}
+	return filtered
+}
+
+// formatTags renders tags as comma-separated key=value pairs sorted by key,
+// e.g. "env=prod, owner=payments".
+func formatTags(tags map[string]string) string {
+	pairs := make([]string, 0, len(tags))
+	for _, name := range slices.Sorted(maps.Keys(tags)) {
+		pairs = append(pairs, name+"="+tags[name])
+	}
+	return strings.Join(pairs, ", ")
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"golang.org/x/time/rate"
+)
+
+// Backoff bounds used when Key Vault does not send a Retry-After header.
+const (
+	retryBaseDelay = time.Second
+	retryMaxDelay  = 30 * time.Second
+)
+
+// defaultAppID is the -app-id default, added to the User-Agent of every
+// request so the tool's traffic is recognizable in Key Vault diagnostic logs.
+const defaultAppID = "azkeyvault-perm-tester"
+
+// maxAppIDLength is the longest application ID azcore sends unshortened.
+const maxAppIDLength = 24
+
+// clientOptions targets the Key Vault clients at cloudCfg and disables the
+// SDK's built-in retries so that withRetry alone decides what is retried and
+// the reported retry counts are accurate. It also widens the header
+// allowlist used by -debug logging, sends requests through transport unless
+// it is nil, and with dryRun intercepts every request before it is sent.
+// Every request carries an x-ms-client-request-id, which failures report, and
+// a User-Agent that starts with appID unless it is empty.
+func clientOptions(cloudCfg cloud.Configuration, transport policy.Transporter, appID string, dryRun bool) azcore.ClientOptions {
+	opts := azcore.ClientOptions{
+		Cloud:           cloudCfg,
+		Retry:           policy.RetryOptions{MaxRetries: -1},
+		Logging:         policy.LogOptions{AllowedHeaders: debugAllowedHeaders},
+		Telemetry:       policy.TelemetryOptions{ApplicationID: appID},
+		Transport:       transport,
+		PerCallPolicies: []policy.Policy{runtime.NewRequestIDPolicy()},
+	}
+	if dryRun {
+		opts.PerCallPolicies = append(opts.PerCallPolicies, dryRunPolicy{})
+	}
+	return opts
+}
+
+// withRetry runs op under the per-operation timeout, retrying throttled
+// (429) and server (5xx) errors up to cfg.maxRetries times. Any other error,
+// in particular a 403, is a deterministic permission result and is returned
+// at once. With -rate-limit, every attempt first waits its turn. It returns
+// the number of retries consumed.
+func (cfg testConfig) withRetry(ctx context.Context, op func(ctx context.Context) error) (int, error) {
+	for retries := 0; ; retries++ {
+		// Wait for the limiter outside the per-operation timeout, which only
+		// bounds the request itself
+		if cfg.limiter != nil {
+			if err := cfg.limiter.Wait(ctx); err != nil {
+				// Wait fails at once when the attempt's turn would come
+				// after the -deadline; wait for the deadline instead, so that
+				// the attempt is reported as cut short by it
+				<-ctx.Done()
+				return retries, ctx.Err()
+			}
+		}
+		err := cfg.withTimeout(ctx, op)
+		if err == nil || retries >= cfg.maxRetries {
+			return retries, err
+		}
+
+		var respErr *azcore.ResponseError
+		if !errors.As(err, &respErr) || !isRetryableStatus(respErr.StatusCode) {
+			return retries, err
+		}
+
+		delay := retryAfter(respErr.RawResponse)
+		if delay <= 0 {
+			delay = backoff(retries)
+		}
+		slog.Info("Retrying after a throttled or server error response", "status", respErr.StatusCode, "retry", retries+1, "delay", delay)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return retries, err
+		case <-timer.C:
+		}
+	}
+}
+
+// newLimiter returns the limiter for -rate-limit: opsPerSecond operations a
+// second with no bursts, so that concurrent tests are spread evenly rather
+// than sent together. It returns nil, no limit, for zero or in a dry run,
+// which sends nothing.
+func newLimiter(opsPerSecond float64, dryRun bool) *rate.Limiter {
+	if opsPerSecond == 0 || dryRun {
+		return nil
+	}
+	return rate.NewLimiter(rate.Limit(opsPerSecond), 1)
+}
+
+func isRetryableStatus(status int) bool {
+	return status == http.StatusTooManyRequests || status >= 500
+}
+
+// backoff returns an exponentially growing delay for the given retry, with
+// jitter so that parallel runs don't retry in lockstep.
+func backoff(retry int) time.Duration {
+	delay := retryMaxDelay
+	if retry < 5 {
+		delay = min(retryBaseDelay<<retry, retryMaxDelay)
+	}
+	return delay/2 + rand.N(delay/2)
+}
+
+// retryAfter returns the delay requested by a throttled response, or zero if
+// it did not ask for one. The millisecond headers Key Vault may send take
+// precedence over the standard Retry-After header.
+func retryAfter(resp *http.Response) time.Duration {
+	if resp == nil {
+		return 0
+	}
+	for _, header := range []string{"retry-after-ms", "x-ms-retry-after-ms"} {
+		if ms, err := strconv.Atoi(resp.Header.Get(header)); err == nil && ms > 0 {
+			return time.Duration(ms) * time.Millisecond
+		}
+	}
+
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(value); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return time.Until(at)
+	}
+	return 0
+}
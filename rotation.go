@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azkeys"
+)
+
+// testRotateAfter is when the policy set by the set rotation policy test
+// rotates the temporary key. The key is deleted long before then.
+const testRotateAfter = "P90D"
+
+// runRotationTests sets a rotation policy on the temporary key named keyName
+// and rotates it, passing each result to record as it completes. keyName is
+// "" when no temporary key was created, and both tests are then skipped. It
+// returns false as soon as record returns false.
+func runRotationTests(ctx context.Context, client keyVaultClient, keyName string, createdID string, cfg testConfig, record func(testResult) bool) bool {
+	if cfg.setRotationPolicy {
+		res := testResult{Operation: opRotationPolicySet}
+		if keyName == "" {
+			res.skip("Set rotation policy testing requires a key created in this run; add -test-create")
+		} else {
+			var policy []string
+			err := cfg.timed(ctx, &res, func(ctx context.Context) (err error) {
+				policy, err = doTestSetRotationPolicy(ctx, client, keyName)
+				return err
+			})
+			if err != nil {
+				res.fail(err)
+			} else {
+				res.Success = true
+				res.RotationPolicy = policy
+			}
+		}
+		if !record(res) {
+			return false
+		}
+	}
+
+	if cfg.rotate {
+		res := testResult{Operation: opRotate}
+		if keyName == "" {
+			res.skip("Rotate testing requires a key created in this run; add -test-create")
+		} else {
+			var info *keyInfo
+			err := cfg.timed(ctx, &res, func(ctx context.Context) (err error) {
+				info, err = doTestRotateKey(ctx, client, keyName)
+				return err
+			})
+			if err != nil {
+				res.fail(err)
+			} else {
+				res.Success = true
+				res.KeyID = info.keyID
+				res.KeyVersion = info.version
+				previousID := azkeys.ID(createdID)
+				if previous := previousID.Version(); previous != "" && previous != info.version {
+					res.Notes = append(res.Notes, fmt.Sprintf("Rotation replaced version %s with %s", previous, info.version))
+				}
+			}
+		}
+		if !record(res) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func doTestGetRotationPolicy(ctx context.Context, client keyVaultClient, keyName string) (azkeys.KeyRotationPolicy, error) {
+	resp, err := client.GetKeyRotationPolicy(ctx, keyName, nil)
+	if err != nil {
+		return azkeys.KeyRotationPolicy{}, fmt.Errorf("get rotation policy operation failed: %w", err)
+	}
+	return resp.KeyRotationPolicy, nil
+}
+
+func doTestSetRotationPolicy(ctx context.Context, client keyVaultClient, keyName string) ([]string, error) {
+	rotate := azkeys.KeyRotationPolicyActionRotate
+	after := testRotateAfter
+	policy := azkeys.KeyRotationPolicy{
+		LifetimeActions: []*azkeys.LifetimeAction{{
+			Action:  &azkeys.LifetimeActionType{Type: &rotate},
+			Trigger: &azkeys.LifetimeActionTrigger{TimeAfterCreate: &after},
+		}},
+	}
+
+	resp, err := client.UpdateKeyRotationPolicy(ctx, keyName, policy, nil)
+	if err != nil {
+		return nil, fmt.Errorf("set rotation policy operation failed: %w", err)
+	}
+	return describeRotationPolicy(resp.KeyRotationPolicy), nil
+}
+
+func doTestRotateKey(ctx context.Context, client keyVaultClient, keyName string) (*keyInfo, error) {
+	resp, err := client.RotateKey(ctx, keyName, nil)
+	if err != nil {
+		return nil, fmt.Errorf("rotate key operation failed: %w", err)
+	}
+
+	info := &keyInfo{}
+	if resp.Key != nil && resp.Key.KID != nil {
+		info.keyID = string(*resp.Key.KID)
+		info.version = resp.Key.KID.Version()
+	}
+	return info, nil
+}
+
+// describeRotationPolicy renders a rotation policy as one line per lifetime
+// action, e.g. "Rotate P90D after creation", plus the expiry of new versions.
+func describeRotationPolicy(policy azkeys.KeyRotationPolicy) []string {
+	var lines []string
+	for _, action := range policy.LifetimeActions {
+		if action == nil || action.Action == nil || action.Action.Type == nil {
+			continue
+		}
+		line := string(*action.Action.Type)
+		if t := action.Trigger; t != nil {
+			if t.TimeAfterCreate != nil {
+				line += " " + *t.TimeAfterCreate + " after creation"
+			}
+			if t.TimeBeforeExpiry != nil {
+				line += " " + *t.TimeBeforeExpiry + " before expiry"
+			}
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) == 0 {
+		lines = append(lines, "No lifetime actions")
+	}
+	if a := policy.Attributes; a != nil && a.ExpiryTime != nil {
+		lines = append(lines, "New versions expire after "+*a.ExpiryTime)
+	}
+	return lines
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azkeys"
+)
+
+// Outcomes of the rotation policy check, reported as rotationCompliance.
+const (
+	rotationCompliant = "compliant"
+
+	// rotationNoPolicy is a key whose rotation policy was never set: Key
+	// Vault answers with its default, which only notifies before expiry.
+	rotationNoPolicy = "noPolicy"
+
+	// rotationNotAutomatic is a policy without a Rotate action.
+	rotationNotAutomatic = "noAutoRotation"
+
+	// rotationTooLax is a policy that rotates less often than required.
+	rotationTooLax = "tooLax"
+)
+
+// rotationExpectation is the longest rotation interval allowed of each
+// tested key, from -expect-rotation-within. The zero value requires nothing.
+type rotationExpectation struct {
+	within time.Duration
+
+	// spec is the flag's value as given, for messages
+	spec string
+}
+
+// parseRotationExpectation validates an -expect-rotation-within value: an
+// ISO 8601 duration as Key Vault writes them, such as P90D, or a Go duration
+// such as 2160h.
+func parseRotationExpectation(spec string) (rotationExpectation, error) {
+	if spec == "" {
+		return rotationExpectation{}, nil
+	}
+	within, err := parseISODuration(spec)
+	if err != nil {
+		if within, err = time.ParseDuration(spec); err != nil {
+			return rotationExpectation{}, fmt.Errorf("-expect-rotation-within: %q is neither an ISO 8601 duration such as P90D nor a duration such as 2160h", spec)
+		}
+	}
+	if within <= 0 {
+		return rotationExpectation{}, fmt.Errorf("-expect-rotation-within must be positive")
+	}
+	return rotationExpectation{within: within, spec: spec}, nil
+}
+
+// enabled reports whether a rotation interval is required.
+func (e rotationExpectation) enabled() bool {
+	return e.within > 0
+}
+
+// isoDuration matches the ISO 8601 durations of rotation policies, such as
+// P90D, P1Y6M, or PT12H.
+var isoDuration = regexp.MustCompile(`^P(?:(\d+)Y)?(?:(\d+)M)?(?:(\d+)W)?(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+)S)?)?$`)
+
+// parseISODuration converts an ISO 8601 duration to a time.Duration. Years
+// count as 365 days and months as 30, which is close enough to compare
+// rotation intervals.
+func parseISODuration(s string) (time.Duration, error) {
+	m := isoDuration.FindStringSubmatch(strings.ToUpper(s))
+	if m == nil || s == "P" || strings.HasSuffix(strings.ToUpper(s), "T") {
+		return 0, fmt.Errorf("invalid ISO 8601 duration %q", s)
+	}
+	const day = 24 * time.Hour
+	units := []time.Duration{365 * day, 30 * day, 7 * day, day, time.Hour, time.Minute, time.Second}
+	var d time.Duration
+	for i, unit := range units {
+		if m[i+1] == "" {
+			continue
+		}
+		n, err := strconv.ParseInt(m[i+1], 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid ISO 8601 duration %q", s)
+		}
+		d += time.Duration(n) * unit
+	}
+	return d, nil
+}
+
+// formatDays renders a rotation interval in days, for messages.
+func formatDays(d time.Duration) string {
+	days := d.Hours() / 24
+	if days == float64(int64(days)) {
+		return fmt.Sprintf("%d days", int64(days))
+	}
+	return fmt.Sprintf("%.1f days", days)
+}
+
+// rotationCheck is the outcome of comparing a rotation policy against the
+// expectation: the compliance status, why the policy fails, and the trigger
+// and expiry time of its rotation, for the report.
+type rotationCheck struct {
+	status     string
+	violation  string
+	trigger    string
+	expiryTime string
+}
+
+// check compares a rotation policy read by the get rotation policy test
+// against the expectation. A Rotate action triggered after creation rotates
+// at that interval; one triggered before expiry rotates at the policy's
+// expiryTime less the trigger, and cannot be bounded without an expiryTime.
+// With several Rotate actions, the most frequent counts.
+func (e rotationExpectation) check(policy azkeys.KeyRotationPolicy) rotationCheck {
+	var c rotationCheck
+	if a := policy.Attributes; a != nil && a.ExpiryTime != nil {
+		c.expiryTime = *a.ExpiryTime
+	}
+	if policy.Attributes == nil || (policy.Attributes.Created == nil && policy.Attributes.Updated == nil) || len(policy.LifetimeActions) == 0 {
+		c.status = rotationNoPolicy
+		c.violation = "the key has no rotation policy, so it is never rotated automatically (-expect-rotation-within)"
+		return c
+	}
+
+	var shortest time.Duration
+	var unbounded string
+	for _, action := range policy.LifetimeActions {
+		if action == nil || action.Action == nil || action.Action.Type == nil || action.Trigger == nil ||
+			!strings.EqualFold(string(*action.Action.Type), string(azkeys.KeyRotationPolicyActionRotate)) {
+			continue
+		}
+		var interval time.Duration
+		var trigger string
+		switch t := action.Trigger; {
+		case t.TimeAfterCreate != nil:
+			trigger = *t.TimeAfterCreate + " after creation"
+			d, err := parseISODuration(*t.TimeAfterCreate)
+			if err != nil {
+				continue
+			}
+			interval = d
+		case t.TimeBeforeExpiry != nil:
+			trigger = *t.TimeBeforeExpiry + " before expiry"
+			before, err := parseISODuration(*t.TimeBeforeExpiry)
+			if err != nil {
+				continue
+			}
+			expiry, err := parseISODuration(c.expiryTime)
+			if err != nil {
+				unbounded = trigger
+				continue
+			}
+			interval = expiry - before
+		default:
+			continue
+		}
+		if shortest == 0 || interval < shortest {
+			shortest, c.trigger = interval, trigger
+		}
+	}
+
+	switch {
+	case shortest > 0 && shortest <= e.within:
+		c.status = rotationCompliant
+	case shortest > 0:
+		c.status = rotationTooLax
+		c.violation = fmt.Sprintf("the key rotates %s (every %s), less often than the required %s (-expect-rotation-within %s)", c.trigger, formatDays(shortest), formatDays(e.within), e.spec)
+	case unbounded != "":
+		c.status = rotationTooLax
+		c.trigger = unbounded
+		c.violation = fmt.Sprintf("the key rotates %s, but the policy sets no expiryTime for new versions, so its rotation interval is not bounded by %s (-expect-rotation-within)", unbounded, formatDays(e.within))
+	default:
+		c.status = rotationNotAutomatic
+		c.violation = "the key's rotation policy has no Rotate action, so it is never rotated automatically (-expect-rotation-within)"
+	}
+	return c
+}
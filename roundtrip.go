@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+
+	"azkeyvault-perm-tester/pkg/permtest"
+)
+
+// Stages of the -roundtrip test, reported as FailedStage.
+const (
+	stageSign   = "sign"
+	stageVerify = "verify"
+
+	// stageCheck is the cryptographic check: Key Vault permitted both
+	// operations, but the signature it produced did not verify.
+	stageCheck = "check"
+)
+
+// stageOperations maps the permission-checking stages to the operation whose
+// permission they need, for remediation hints.
+var stageOperations = map[string]string{
+	stageSign:   opSign,
+	stageVerify: opVerify,
+}
+
+// roundTrip signs digest with cfg.sigAlgorithm and verifies the signature
+// with the same algorithm, as one result. It returns the result and the
+// signature, or nil if signing failed.
+func (t tester) roundTrip(ctx context.Context, digest []byte, digestErr error) (testResult, []byte) {
+	res := testResult{Operation: opRoundTrip}
+	if w := t.cfg.payload.hashWarning(t.cfg.sigAlgorithm); w != "" {
+		res.Warnings = append(res.Warnings, w)
+	}
+	if digestErr != nil {
+		res.fail(digestErr)
+		return res, nil
+	}
+
+	var signature []byte
+	var valid bool
+	stage := stageSign
+	err := t.cfg.timed(ctx, &res, func(ctx context.Context) error {
+		stage = stageSign
+		sign, sig := t.keyOps().Sign(ctx, digest)
+		if sign.Err != nil {
+			return sign.Err
+		}
+		signature, res.KeyVersion, res.SigningKeyID = sig, sign.KeyVersion, sign.KeyID
+		stage = stageVerify
+		var verify permtest.Result
+		verify, valid = t.keyOps().Verify(ctx, digest, signature)
+		return verify.Err
+	})
+
+	switch {
+	case err != nil:
+		res.fail(err)
+		if !res.Skipped {
+			res.FailedStage = stage
+			res.Remediation = remediation(stageOperations[stage], res.AuthorizationModel)
+			if stage == stageVerify {
+				res.Notes = append(res.Notes, "SIGN succeeded; VERIFY of the signature failed")
+			}
+		}
+	case !valid:
+		res.FailedStage = stageCheck
+		res.Signature = base64.StdEncoding.EncodeToString(signature)
+		res.mismatch(fmt.Sprintf("the signature Key Vault produced with %s did not verify with %s", t.cfg.sigAlgorithm, t.cfg.sigAlgorithm))
+	default:
+		res.Success = true
+		res.Signature = base64.StdEncoding.EncodeToString(signature)
+		saveSignature(t.cfg, &res, signature)
+	}
+	if err != nil {
+		return res, nil
+	}
+	return res, signature
+}
+
+// saveSignature writes signature to -signature-out, if set, noting the
+// outcome on res.
+func saveSignature(cfg testConfig, res *testResult, signature []byte) {
+	if cfg.signatureOut == "" {
+		return
+	}
+	if err := writeSignatureFile(cfg.signatureOut, signature, cfg.signatureEncoding); err != nil {
+		res.Warnings = append(res.Warnings, err.Error())
+	} else {
+		res.Notes = append(res.Notes, fmt.Sprintf("Signature written to %s (%s)", cfg.signatureOut, cfg.signatureEncoding))
+	}
+}
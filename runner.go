@@ -0,0 +1,433 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+)
+
+// runner runs the selected tests in every vault of a run and reports each
+// result as it completes. main builds it from the flags and turns whether
+// the run failed into the exit code.
+type runner struct {
+	cfg      testConfig
+	vaults   []vaultTarget
+	keyNames []string
+
+	// allKeys tests every key each vault lists, for -test-all-keys, and
+	// concurrency is how many keys of a vault are tested at once
+	allKeys     bool
+	concurrency int
+
+	// strict stops the run at the first failure, and failOnSkip fails it
+	// for any skipped test
+	strict     bool
+	failOnSkip bool
+
+	// checkFirst runs the pre-flight check of each vault before its tests
+	// and records a failure as the vault's only result, for
+	// -continue-on-auth-error
+	checkFirst bool
+
+	// skipRecent is the -skip-recent window, used with cache
+	skipRecent time.Duration
+
+	// cloud is set on every report when the vaults are not in the public
+	// cloud
+	cloud string
+
+	// clients creates the clients of each vault, as the identity under test
+	clients vaultClients
+
+	rep reporter
+
+	// streamer is rep if it streams results as they are recorded, or nil
+	streamer resultStreamer
+
+	// cache records the tests that pass, for -skip-recent, or is nil
+	cache *passCache
+
+	// mu guards failed and skipped while keys are tested concurrently
+	mu     sync.Mutex
+	failed bool
+
+	// skipped counts the skipped results that fail the run with
+	// -fail-on-skip
+	skipped int
+}
+
+// run tests every vault in turn and returns the reports, stopping early on
+// an interrupt or, with -strict, the first failure.
+func (r *runner) run(ctx context.Context) []*runReport {
+	var reports []*runReport
+	for _, vault := range r.vaults {
+		vaultReports, completed := r.runVault(ctx, vault, r.clients.key(vault))
+		reports = append(reports, vaultReports...)
+		if !completed {
+			break
+		}
+	}
+	return reports
+}
+
+// runVault runs the selected tests of one vault with client: the vault-wide
+// tests, then the secret and certificate tests, then the tests of each key.
+// It returns the reports and whether the run should go on to the next vault.
+func (r *runner) runVault(ctx context.Context, vault vaultTarget, client keyVaultClient) ([]*runReport, bool) {
+	var reports []*runReport
+	cfg := r.cfg
+	cfg.managedHSM = vault.managedHSM
+
+	// runReport records the tests of one report, returning whether the run
+	// should go on
+	runReport := func(report *runReport, tests func(record func(testResult) bool) bool) bool {
+		reports = append(reports, report)
+		r.rep.beginKey(report)
+		completed := tests(r.recordTo(ctx, report))
+		r.rep.endKey(report)
+		if !completed {
+			r.stop(ctx, report)
+		}
+		return completed
+	}
+
+	// With -continue-on-auth-error, checking the vault first keeps a
+	// credential or connection failure to one result for the vault, and the
+	// sweep goes on to the next
+	if r.checkFirst {
+		if err := r.clients.check(vault, client); err != nil {
+			slog.Warn("Vault failed the pre-flight check; skipping its tests", "vault", vault.url)
+			res := testResult{Operation: opPreflight}
+			res.fail(err)
+			completed := runReport(r.newReport(vault), func(record func(testResult) bool) bool {
+				return record(res)
+			})
+			return reports, completed
+		}
+	}
+
+	if cfg.vaultTests() {
+		if !runReport(r.newReport(vault), func(record func(testResult) bool) bool {
+			return r.runVaultTests(ctx, client, cfg, record)
+		}) {
+			return reports, false
+		}
+	}
+
+	if cfg.secretTests() {
+		report := r.newReport(vault)
+		report.SecretName = cfg.secretName
+		if !cfg.secretGet {
+			report.SecretName = cfg.secretSetName
+		}
+		secretClient := r.clients.secret(vault)
+		if !runReport(report, func(record func(testResult) bool) bool {
+			return runSecretTests(ctx, secretClient, cfg, record)
+		}) {
+			return reports, false
+		}
+	}
+
+	if cfg.certificateTests() {
+		report := r.newReport(vault)
+		report.CertificateName = cfg.certName
+		certClient := r.clients.certificate(vault)
+		if !runReport(report, func(record func(testResult) bool) bool {
+			return runCertificateTests(ctx, certClient, cfg, record)
+		}) {
+			return reports, false
+		}
+	}
+
+	names := r.keyNames
+	if r.allKeys {
+		var skipped *testResult
+		names, skipped = r.discoverKeys(ctx, client, cfg)
+		if skipped != nil {
+			report := r.newReport(vault)
+			reports = append(reports, report)
+			r.rep.beginKey(report)
+			r.recordTo(ctx, report)(*skipped)
+			r.rep.endKey(report)
+		}
+	}
+
+	keyReports, completed := r.runKeys(ctx, vault, client, cfg, names)
+	return append(reports, keyReports...), completed
+}
+
+// runVaultTests runs the selected vault-wide tests with client, passing each
+// result to record as it completes. It stops early and returns false as soon
+// as record returns false.
+func (r *runner) runVaultTests(ctx context.Context, client keyVaultClient, cfg testConfig, record func(testResult) bool) bool {
+	if cfg.list {
+		res := testResult{Operation: opList}
+		var names []string
+		err := cfg.timed(ctx, &res, func(ctx context.Context) (err error) {
+			names, err = r.listKeys(ctx, client)
+			return err
+		})
+		if err != nil {
+			res.fail(err)
+		} else {
+			res.Success = true
+			count := len(names)
+			res.KeyCount = &count
+			if cfg.verbose {
+				res.KeyNames = names
+			}
+		}
+		if !record(res) {
+			return false
+		}
+	}
+
+	if cfg.random {
+		if !record(testRandomBytes(ctx, client, cfg)) {
+			return false
+		}
+	}
+
+	return runKeyLifecycleTests(ctx, client, cfg, record)
+}
+
+// listKeys returns the names of the keys client's vault lists.
+func (r *runner) listKeys(ctx context.Context, client keyVaultClient) ([]string, error) {
+	var names []string
+	pager := client.NewListKeyPropertiesPager(nil)
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("list keys operation failed: %w", err)
+		}
+		for _, key := range page.Value {
+			if key.KID != nil {
+				names = append(names, key.KID.Name())
+			}
+		}
+	}
+
+	return names, nil
+}
+
+// discoverKeys lists the vault's keys for -test-all-keys and returns them
+// after the keys given with -key-name, without duplicates. If the keys cannot
+// be listed, for example without the list permission, it returns the named
+// keys alone with a skipped LIST result that explains why.
+func (r *runner) discoverKeys(ctx context.Context, client keyVaultClient, cfg testConfig) ([]string, *testResult) {
+	var listed []string
+	_, err := cfg.withRetry(ctx, func(ctx context.Context) (err error) {
+		listed, err = r.listKeys(ctx, client)
+		return err
+	})
+	if err != nil {
+		res := testResult{Operation: opList}
+		res.fail(err)
+		if !res.Skipped {
+			c := classifyError(err)
+			res = testResult{Operation: opList, Remediation: res.Remediation}
+			res.skip(fmt.Sprintf("Keys could not be listed for -test-all-keys (%s: %s), so no discovered keys were tested", c.label(), c.message))
+		}
+		return r.keyNames, &res
+	}
+
+	names := slices.Clone(r.keyNames)
+	for _, name := range listed {
+		if !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// runKeys runs the per-key tests of each named key in vault with client,
+// one key at a time or, with -concurrency, several at once. It returns the
+// reports, in key name order when concurrent, and whether the run should go
+// on.
+func (r *runner) runKeys(ctx context.Context, vault vaultTarget, client keyVaultClient, cfg testConfig, names []string) ([]*runReport, bool) {
+	var reports []*runReport
+	if r.concurrency == 1 {
+		for _, name := range names {
+			report, keyCfg := r.keyReport(ctx, vault, client, cfg, name)
+			reports = append(reports, report)
+
+			r.rep.beginKey(report)
+			completed := tester{client: client, keyName: name, cfg: keyCfg}.runKeyTests(ctx, r.recordTo(ctx, report))
+			r.rep.endKey(report)
+
+			if !completed {
+				r.stop(ctx, report)
+				return reports, false
+			}
+		}
+		return reports, true
+	}
+
+	// Results are buffered per key and printed once every key is done
+	keyReports := runKeysConcurrently(names, r.concurrency, func(name string) *runReport {
+		r.mu.Lock()
+		stopped := (r.strict && r.failed) || (ctx.Err() != nil && !deadlineReached(ctx))
+		r.mu.Unlock()
+		if stopped {
+			return nil
+		}
+
+		report, keyCfg := r.keyReport(ctx, vault, client, cfg, name)
+		if !(tester{client: client, keyName: name, cfg: keyCfg}).runKeyTests(ctx, r.bufferTo(ctx, report)) {
+			r.stop(ctx, report)
+		}
+		return report
+	})
+
+	completed := true
+	for _, report := range keyReports {
+		reports = append(reports, report)
+
+		r.rep.beginKey(report)
+		for _, res := range report.Results {
+			r.rep.result(res)
+		}
+		r.rep.endKey(report)
+
+		if report.Aborted || report.Interrupted {
+			completed = false
+		}
+	}
+	return reports, completed
+}
+
+// compare runs the tests as the identity of r.clients and again as that of
+// other, for -compare-with, reporting neither run, and writes how the
+// outcomes of the two identities compare to w. It returns how many
+// operations had different outcomes.
+func (r *runner) compare(ctx context.Context, other vaultClients, w io.Writer, label, otherLabel string) (int, error) {
+	r.rep = multiReporter{}
+	first := r.run(ctx)
+	r.failed = false
+	r.clients = other
+	second := r.run(ctx)
+	return writeComparison(w, label, otherLabel, compareReports(first, second), len(r.vaults) > 1)
+}
+
+// finish saves the cache of passed tests and writes the report of a run.
+func (r *runner) finish(reports []*runReport) error {
+	if r.cache != nil {
+		if err := r.cache.save(); err != nil {
+			slog.Warn("Failed to update the cache of passed tests", "error", err)
+		}
+	}
+	return r.rep.finish(reports)
+}
+
+// openPassCache loads the cache of passed tests for -skip-recent, keyed by
+// the identity of cred. When the cache cannot be used, it logs why and
+// returns nil, so that every test is rerun.
+func openPassCache(ctx context.Context, auth authConfig, cred azcore.TokenCredential, claims *tokenClaims, scope string, timeout, within time.Duration) *passCache {
+	identity, err := cacheIdentity(ctx, auth, cred, claims, scope, timeout)
+	path := ""
+	if err == nil {
+		path, err = defaultPassCachePath()
+	}
+	var cache *passCache
+	if err == nil {
+		cache, err = loadPassCache(path, identity)
+	}
+	if err != nil {
+		slog.Warn("Cache of passed tests unavailable; rerunning every test", "error", err)
+		return nil
+	}
+	slog.Info("Skipping tests that passed recently", "within", within, "cache", path)
+	return cache
+}
+
+// newReport returns an empty report of vault.
+func (r *runner) newReport(vault vaultTarget) *runReport {
+	return &runReport{VaultURL: vault.url, ManagedHSM: vault.managedHSM, Cloud: r.cloud}
+}
+
+// keyReport prepares the report and configuration for testing one key.
+func (r *runner) keyReport(ctx context.Context, vault vaultTarget, client keyVaultClient, cfg testConfig, name string) (*runReport, testConfig) {
+	report := r.newReport(vault)
+	report.KeyName = name
+	report.KeyVersion = cfg.keyVersion
+	report.Algorithm = string(cfg.sigAlgorithm)
+	if cfg.encrypt || cfg.decrypt {
+		report.EncryptionAlgorithm = string(cfg.encryptionAlgorithm)
+	}
+	if cfg.wrap || cfg.unwrap {
+		report.WrapAlgorithm = string(cfg.wrapAlgorithm)
+	}
+
+	keyCfg := cfg
+	if r.cache != nil {
+		keyCfg.recentPasses = r.cache.recentPasses(vault.url, name, r.skipRecent)
+	}
+	if cfg.allAlgorithms && cfg.usesSignatureAlgorithm() {
+		report.Algorithm = ""
+		report.AllAlgorithms = true
+	} else if cfg.autoAlgorithm && cfg.usesSignatureAlgorithm() {
+		keyCfg.sigAlgorithm, report.AlgorithmSource = resolveAlgorithm(ctx, client, name, cfg)
+		report.Algorithm = string(keyCfg.sigAlgorithm)
+	}
+	return report, keyCfg
+}
+
+// stop marks a report whose tests were cut short, by an interrupt or by
+// -strict.
+func (r *runner) stop(ctx context.Context, report *runReport) {
+	if ctx.Err() != nil && !deadlineReached(ctx) {
+		report.Interrupted = true
+	} else {
+		report.Aborted = true
+	}
+}
+
+// settle records a test that -deadline cut short as skipped.
+func settle(ctx context.Context, res testResult) testResult {
+	if deadlineReached(ctx) && cutShort(res) {
+		res.skipDeadline()
+	}
+	return res
+}
+
+// bufferTo returns a callback that adds results to report without printing
+// them. An interrupt, or in strict mode the first failure, stops any
+// remaining tests. Once -deadline passes, the remaining tests still run,
+// failing at once, and are recorded as skipped.
+func (r *runner) bufferTo(ctx context.Context, report *runReport) func(testResult) bool {
+	return func(res testResult) bool {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		res = settle(ctx, res)
+		report.Results = append(report.Results, res)
+		if r.streamer != nil {
+			r.streamer.stream(report, res)
+		}
+		if r.cache != nil {
+			r.cache.observe(report, res)
+		}
+		if isFailure(res) {
+			r.failed = true
+		}
+		if r.failOnSkip && res.Skipped && !res.NotApplicable {
+			r.skipped++
+		}
+		return !(r.strict && r.failed) && (ctx.Err() == nil || deadlineReached(ctx))
+	}
+}
+
+// recordTo is bufferTo that also prints each result as it completes.
+func (r *runner) recordTo(ctx context.Context, report *runReport) func(testResult) bool {
+	record := r.bufferTo(ctx, report)
+	return func(res testResult) bool {
+		res = settle(ctx, res)
+		r.rep.result(res)
+		return record(res)
+	}
+}
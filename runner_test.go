@@ -0,0 +1,369 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azkeys"
+)
+
+var (
+	vaultA = vaultTarget{url: "https://a.vault.azure.net/"}
+	vaultB = vaultTarget{url: "https://b.vault.azure.net/"}
+)
+
+// eventReporter is a reporter that records what it is told, in order.
+type eventReporter struct {
+	events []string
+}
+
+func (e *eventReporter) beginKey(r *runReport) {
+	e.events = append(e.events, "begin "+r.VaultURL+" "+r.KeyName)
+}
+
+func (e *eventReporter) result(res testResult) {
+	e.events = append(e.events, "result "+res.Operation)
+}
+
+func (e *eventReporter) endKey(r *runReport) {
+	e.events = append(e.events, "end "+r.VaultURL+" "+r.KeyName)
+}
+
+func (e *eventReporter) finish(reports []*runReport) error {
+	return nil
+}
+
+// newFakeRunner returns a runner of the sign and verify tests of keyNames in
+// each vault, sending every request to the client clients returns for it.
+func newFakeRunner(vaults []vaultTarget, keyNames []string, clients func(vault vaultTarget) *fakeClient) *runner {
+	return &runner{
+		cfg: testConfig{
+			sign:         true,
+			verify:       true,
+			timeout:      time.Second,
+			sigAlgorithm: azkeys.SignatureAlgorithmRS256,
+		},
+		vaults:      vaults,
+		keyNames:    keyNames,
+		concurrency: 1,
+		clients:     fakeClients(clients),
+		rep:         &eventReporter{},
+	}
+}
+
+// fakeClients returns vaultClients whose key clients are those clients
+// returns, and whose pre-flight check passes.
+func fakeClients(clients func(vault vaultTarget) *fakeClient) vaultClients {
+	return vaultClients{
+		key: func(vault vaultTarget) keyVaultClient {
+			return clients(vault)
+		},
+		check: func(vault vaultTarget, client keyVaultClient) error {
+			return nil
+		},
+	}
+}
+
+// reportLines lists each report as its vault, key, and the operations of its
+// results.
+func reportLines(reports []*runReport) []string {
+	var lines []string
+	for _, r := range reports {
+		line := r.VaultURL + " " + r.KeyName + ":"
+		for _, res := range r.Results {
+			line += " " + res.Operation
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func TestRunnerRunsEveryVaultAndKey(t *testing.T) {
+	clients := map[string]*fakeClient{vaultA.url: {}, vaultB.url: {}}
+	r := newFakeRunner([]vaultTarget{vaultA, vaultB}, []string{"k1", "k2"}, func(vault vaultTarget) *fakeClient {
+		return clients[vault.url]
+	})
+
+	reports := r.run(context.Background())
+	want := []string{
+		vaultA.url + " k1: sign verify",
+		vaultA.url + " k2: sign verify",
+		vaultB.url + " k1: sign verify",
+		vaultB.url + " k2: sign verify",
+	}
+	if got := reportLines(reports); !slices.Equal(got, want) {
+		t.Errorf("reports = %q, want %q", got, want)
+	}
+	if r.failed {
+		t.Error("failed is set after every test passed")
+	}
+	for url, f := range clients {
+		if n := f.called(opSign); n != 2 {
+			t.Errorf("%s: sign called %d times, want 2", url, n)
+		}
+	}
+
+	events := r.rep.(*eventReporter).events
+	wantEvents := []string{"begin " + vaultA.url + " k1", "result " + opSign, "result " + opVerify, "end " + vaultA.url + " k1"}
+	if !slices.Equal(events[:4], wantEvents) {
+		t.Errorf("first events = %q, want %q", events[:4], wantEvents)
+	}
+}
+
+func TestRunnerRecordsFailure(t *testing.T) {
+	f := &fakeClient{errs: map[string]error{opSign: forbidden()}}
+	r := newFakeRunner([]vaultTarget{vaultA}, []string{"k1", "k2"}, func(vaultTarget) *fakeClient { return f })
+
+	reports := r.run(context.Background())
+	if len(reports) != 2 {
+		t.Fatalf("got %d reports, want 2: a failure stops the run only with -strict", len(reports))
+	}
+	if !r.failed {
+		t.Error("failed is not set after a forbidden sign")
+	}
+	for _, report := range reports {
+		if report.Aborted || report.Interrupted {
+			t.Errorf("%s: report stopped without -strict", report.KeyName)
+		}
+		if res := report.Results[0]; res.Operation != opSign || !isFailure(res) || res.ErrorCategory != categoryForbidden {
+			t.Errorf("%s: first result = %+v, want a Forbidden sign", report.KeyName, res)
+		}
+	}
+}
+
+func TestRunnerStrictStopsAtFirstFailure(t *testing.T) {
+	f := &fakeClient{errs: map[string]error{opSign: forbidden()}}
+	r := newFakeRunner([]vaultTarget{vaultA, vaultB}, []string{"k1", "k2"}, func(vaultTarget) *fakeClient { return f })
+	r.strict = true
+
+	reports := r.run(context.Background())
+	if got, want := reportLines(reports), []string{vaultA.url + " k1: sign"}; !slices.Equal(got, want) {
+		t.Errorf("reports = %q, want %q", got, want)
+	}
+	if !reports[0].Aborted {
+		t.Error("report stopped by -strict is not marked aborted")
+	}
+	if n := f.called(opVerify); n != 0 {
+		t.Errorf("verify called %d times after -strict stopped the run", n)
+	}
+}
+
+func TestRunnerInterrupted(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	f := &fakeClient{}
+	r := newFakeRunner([]vaultTarget{vaultA, vaultB}, []string{"k1", "k2"}, func(vaultTarget) *fakeClient {
+		cancel()
+		return f
+	})
+
+	reports := r.run(ctx)
+	if len(reports) != 1 {
+		t.Fatalf("got %d reports, want 1: an interrupt stops the run", len(reports))
+	}
+	if !reports[0].Interrupted {
+		t.Error("report stopped by an interrupt is not marked interrupted")
+	}
+}
+
+func TestRunnerConcurrentKeysInNameOrder(t *testing.T) {
+	f := &fakeClient{}
+	names := []string{"k3", "k1", "k4", "k2"}
+	r := newFakeRunner([]vaultTarget{vaultA}, names, func(vaultTarget) *fakeClient { return f })
+	r.concurrency = 3
+
+	reports := r.run(context.Background())
+	want := []string{
+		vaultA.url + " k1: sign verify",
+		vaultA.url + " k2: sign verify",
+		vaultA.url + " k3: sign verify",
+		vaultA.url + " k4: sign verify",
+	}
+	if got := reportLines(reports); !slices.Equal(got, want) {
+		t.Errorf("reports = %q, want %q", got, want)
+	}
+
+	// Buffered results are reported once every key is done, key by key
+	events := r.rep.(*eventReporter).events
+	if len(events) != 4*len(names) {
+		t.Fatalf("got %d events, want %d", len(events), 4*len(names))
+	}
+	for i, report := range reports {
+		if events[4*i] != "begin "+vaultA.url+" "+report.KeyName || events[4*i+3] != "end "+vaultA.url+" "+report.KeyName {
+			t.Errorf("events of %s = %q", report.KeyName, events[4*i:4*i+4])
+		}
+	}
+}
+
+func TestRunnerPreflightFailureSkipsVault(t *testing.T) {
+	clients := map[string]*fakeClient{vaultA.url: {}, vaultB.url: {}}
+	r := newFakeRunner([]vaultTarget{vaultA, vaultB}, []string{"k1"}, func(vault vaultTarget) *fakeClient {
+		return clients[vault.url]
+	})
+	r.checkFirst = true
+	r.clients.check = func(vault vaultTarget, client keyVaultClient) error {
+		if vault == vaultA {
+			return errors.New("connection refused")
+		}
+		return nil
+	}
+
+	reports := r.run(context.Background())
+	want := []string{
+		vaultA.url + " : " + opPreflight,
+		vaultB.url + " k1: sign verify",
+	}
+	if got := reportLines(reports); !slices.Equal(got, want) {
+		t.Errorf("reports = %q, want %q", got, want)
+	}
+	if !r.failed {
+		t.Error("failed is not set after a failed pre-flight check")
+	}
+	if n := clients[vaultA.url].called(opSign); n != 0 {
+		t.Errorf("sign called %d times in a vault that failed the pre-flight check", n)
+	}
+}
+
+func TestRunnerFailOnSkip(t *testing.T) {
+	f := &fakeClient{}
+	r := newFakeRunner([]vaultTarget{vaultA}, []string{"k1"}, func(vaultTarget) *fakeClient { return f })
+	// No digest is defined for an unknown algorithm, so sign and verify are
+	// skipped without a request
+	r.cfg.sigAlgorithm = "XS999"
+	r.failOnSkip = true
+
+	r.run(context.Background())
+	if r.skipped == 0 {
+		t.Error("skipped is not counted with -fail-on-skip")
+	}
+	if n := f.called(opSign); n != 0 {
+		t.Errorf("sign called %d times with an unknown algorithm", n)
+	}
+}
+
+func TestRunnerDiscoversKeys(t *testing.T) {
+	f := &fakeClient{keys: []string{"k2", "k1", "k3"}}
+	r := newFakeRunner([]vaultTarget{vaultA}, []string{"k1"}, func(vaultTarget) *fakeClient { return f })
+	r.allKeys = true
+
+	reports := r.run(context.Background())
+	want := []string{
+		vaultA.url + " k1: sign verify",
+		vaultA.url + " k2: sign verify",
+		vaultA.url + " k3: sign verify",
+	}
+	if got := reportLines(reports); !slices.Equal(got, want) {
+		t.Errorf("reports = %q, want %q", got, want)
+	}
+}
+
+func TestRunnerDiscoveryForbidden(t *testing.T) {
+	f := &fakeClient{keys: []string{"k2"}, errs: map[string]error{opList: forbidden()}}
+	r := newFakeRunner([]vaultTarget{vaultA}, []string{"k1"}, func(vaultTarget) *fakeClient { return f })
+	r.allKeys = true
+
+	reports := r.run(context.Background())
+	want := []string{
+		vaultA.url + " : " + opList,
+		vaultA.url + " k1: sign verify",
+	}
+	if got := reportLines(reports); !slices.Equal(got, want) {
+		t.Fatalf("reports = %q, want %q", got, want)
+	}
+	if res := reports[0].Results[0]; !res.Skipped || res.Remediation == "" {
+		t.Errorf("list result = %+v, want a skip with remediation", res)
+	}
+	if r.failed {
+		t.Error("failed is set when only the key discovery was refused")
+	}
+}
+
+func TestRunnerListTest(t *testing.T) {
+	f := &fakeClient{keys: []string{"k1", "k2"}}
+	r := newFakeRunner([]vaultTarget{vaultA}, nil, func(vaultTarget) *fakeClient { return f })
+	r.cfg = testConfig{list: true, verbose: true, timeout: time.Second}
+
+	reports := r.run(context.Background())
+	if got, want := reportLines(reports), []string{vaultA.url + " : " + opList}; !slices.Equal(got, want) {
+		t.Fatalf("reports = %q, want %q", got, want)
+	}
+	res := reports[0].Results[0]
+	if !res.Success || res.KeyCount == nil || *res.KeyCount != 2 || !slices.Equal(res.KeyNames, f.keys) {
+		t.Errorf("list result = %+v, want 2 keys listed", res)
+	}
+}
+
+func TestRunnerCompare(t *testing.T) {
+	allowed := &fakeClient{}
+	refused := &fakeClient{errs: map[string]error{opSign: forbidden()}}
+	r := newFakeRunner([]vaultTarget{vaultA}, []string{"k1"}, func(vaultTarget) *fakeClient { return allowed })
+	rep := r.rep.(*eventReporter)
+
+	var out bytes.Buffer
+	differ, err := r.compare(context.Background(), fakeClients(func(vaultTarget) *fakeClient { return refused }), &out, "first", "second")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if differ == 0 {
+		t.Errorf("differ = 0, want the refused sign counted\n%s", out.String())
+	}
+	if !strings.Contains(out.String(), "first") || !strings.Contains(out.String(), "second") {
+		t.Errorf("comparison does not name both identities:\n%s", out.String())
+	}
+	if allowed.called(opSign) != 1 || refused.called(opSign) != 1 {
+		t.Errorf("sign called %d and %d times, want once as each identity", allowed.called(opSign), refused.called(opSign))
+	}
+	if len(rep.events) != 0 {
+		t.Errorf("compare reported %d events, want none", len(rep.events))
+	}
+}
+
+func TestRunnerCompareSame(t *testing.T) {
+	f := &fakeClient{}
+	r := newFakeRunner([]vaultTarget{vaultA}, []string{"k1"}, func(vaultTarget) *fakeClient { return f })
+
+	var out bytes.Buffer
+	differ, err := r.compare(context.Background(), r.clients, &out, "first", "second")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if differ != 0 {
+		t.Errorf("differ = %d with the same identity twice, want 0\n%s", differ, out.String())
+	}
+}
+
+func TestRunnerSkipsRecentPasses(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	auth := authConfig{mode: authModeSPSecret, clientID: "fake-client"}
+	run := func() (*fakeClient, []*runReport) {
+		f := &fakeClient{}
+		r := newFakeRunner([]vaultTarget{vaultA}, []string{"k1"}, func(vaultTarget) *fakeClient { return f })
+		r.skipRecent = time.Hour
+		r.cache = openPassCache(context.Background(), auth, nil, nil, "", time.Second, r.skipRecent)
+		if r.cache == nil {
+			t.Fatal("cache of passed tests unavailable")
+		}
+		reports := r.run(context.Background())
+		if err := r.finish(reports); err != nil {
+			t.Fatal(err)
+		}
+		return f, reports
+	}
+
+	if f, _ := run(); f.called(opSign) != 1 {
+		t.Fatalf("sign called %d times on the first run, want 1", f.called(opSign))
+	}
+	f, reports := run()
+	if n := f.called(opSign) + f.called(opVerify); n != 0 {
+		t.Errorf("%d requests sent for tests that passed within -skip-recent", n)
+	}
+	for _, res := range reports[0].Results {
+		if !res.Success || res.CachedPass == nil {
+			t.Errorf("%s result = %+v, want a cached pass", res.Operation, res)
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// SARIF 2.1.0 elements, covering what GitHub code scanning reads.
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name    string      `json:"name"`
+	Version string      `json:"version,omitempty"`
+	Rules   []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID                   string             `json:"id"`
+	Name                 string             `json:"name"`
+	ShortDescription     sarifMessage       `json:"shortDescription"`
+	FullDescription      sarifMessage       `json:"fullDescription"`
+	DefaultConfiguration sarifConfiguration `json:"defaultConfiguration"`
+	Properties           sarifRuleProps     `json:"properties"`
+}
+
+type sarifConfiguration struct {
+	Level string `json:"level"`
+}
+
+// sarifRuleProps carries the severity GitHub code scanning ranks alerts by,
+// from 0.0 to 10.0.
+type sarifRuleProps struct {
+	SecuritySeverity string   `json:"security-severity"`
+	Tags             []string `json:"tags"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID              string            `json:"ruleId"`
+	RuleIndex           int               `json:"ruleIndex"`
+	Level               string            `json:"level"`
+	Message             sarifMessage      `json:"message"`
+	Locations           []sarifLocation   `json:"locations"`
+	PartialFingerprints map[string]string `json:"partialFingerprints"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation  `json:"physicalLocation"`
+	LogicalLocations []sarifLogicalLocation `json:"logicalLocations"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+type sarifLogicalLocation struct {
+	Name               string `json:"name"`
+	FullyQualifiedName string `json:"fullyQualifiedName"`
+	Kind               string `json:"kind"`
+}
+
+// sarifRules are the kinds of finding -output sarif reports, in the order of
+// the driver's rules array.
+var sarifRules = []sarifRule{
+	{
+		ID:                   "azkv/permission-denied",
+		Name:                 "PermissionDenied",
+		ShortDescription:     sarifMessage{Text: "Key Vault denied an operation"},
+		FullDescription:      sarifMessage{Text: "The identity was not permitted to perform the operation: it lacks the RBAC role or access policy permission, or the vault's firewall rejected the request."},
+		DefaultConfiguration: sarifConfiguration{Level: "error"},
+		Properties:           sarifRuleProps{SecuritySeverity: "7.0", Tags: []string{"security", "access-control"}},
+	},
+	{
+		ID:                   "azkv/unauthenticated",
+		Name:                 "Unauthenticated",
+		ShortDescription:     sarifMessage{Text: "Key Vault rejected the caller's token"},
+		FullDescription:      sarifMessage{Text: "The request was not authenticated: the token is missing, expired, issued by the wrong tenant, or for the wrong audience."},
+		DefaultConfiguration: sarifConfiguration{Level: "error"},
+		Properties:           sarifRuleProps{SecuritySeverity: "5.0", Tags: []string{"security", "authentication"}},
+	},
+	{
+		ID:                   "azkv/weak-key",
+		Name:                 "WeakKey",
+		ShortDescription:     sarifMessage{Text: "A key is weaker than the key policy allows"},
+		FullDescription:      sarifMessage{Text: "The key's RSA modulus is smaller than -min-rsa-bits, or its curve is not in -allowed-curves."},
+		DefaultConfiguration: sarifConfiguration{Level: "warning"},
+		Properties:           sarifRuleProps{SecuritySeverity: "6.0", Tags: []string{"security", "cryptography"}},
+	},
+	{
+		ID:                   "azkv/result-mismatch",
+		Name:                 "ResultMismatch",
+		ShortDescription:     sarifMessage{Text: "A permitted operation returned a wrong result"},
+		FullDescription:      sarifMessage{Text: "Key Vault performed the operation, but a round trip returned different bytes or a signature check gave the wrong answer."},
+		DefaultConfiguration: sarifConfiguration{Level: "error"},
+		Properties:           sarifRuleProps{SecuritySeverity: "8.0", Tags: []string{"security", "cryptography"}},
+	},
+	{
+		ID:                   "azkv/operation-failed",
+		Name:                 "OperationFailed",
+		ShortDescription:     sarifMessage{Text: "A Key Vault operation failed"},
+		FullDescription:      sarifMessage{Text: "The operation failed for a reason other than permissions, such as a missing key, a disabled key, a timeout, or a network error."},
+		DefaultConfiguration: sarifConfiguration{Level: "warning"},
+		Properties:           sarifRuleProps{SecuritySeverity: "3.0", Tags: []string{"security"}},
+	},
+	{
+		ID:                   "azkv/rotation-policy",
+		Name:                 "RotationPolicy",
+		ShortDescription:     sarifMessage{Text: "A key is not rotated as often as required"},
+		FullDescription:      sarifMessage{Text: "The key has no rotation policy, its policy does not rotate it automatically, or it rotates less often than -expect-rotation-within."},
+		DefaultConfiguration: sarifConfiguration{Level: "warning"},
+		Properties:           sarifRuleProps{SecuritySeverity: "5.0", Tags: []string{"security", "key-lifecycle"}},
+	},
+}
+
+// sarifRuleIndex returns the index in sarifRules of the rule a failed
+// result falls under.
+func sarifRuleIndex(res testResult) int {
+	switch res.ErrorCategory {
+	case categoryForbidden:
+		return 0
+	case categoryUnauthorized:
+		return 1
+	case categoryPolicy:
+		if res.Operation == opRotationPolicyCheck {
+			return 5
+		}
+		return 2
+	case categoryMismatch:
+		return 3
+	}
+	return 4
+}
+
+// sarifReporter emits the failures of the run as a SARIF log for -output
+// sarif, once it completes, so that code scanning tools can show them with
+// other security findings. Each failed test becomes a result; passed and
+// skipped tests produce none.
+type sarifReporter struct {
+	w io.Writer
+}
+
+func (s *sarifReporter) beginKey(r *runReport) {}
+
+func (s *sarifReporter) result(res testResult) {}
+
+func (s *sarifReporter) endKey(r *runReport) {}
+
+func (s *sarifReporter) finish(reports []*runReport) error {
+	run := sarifRun{
+		Tool: sarifTool{Driver: sarifDriver{
+			Name:    "azkeyvault-perm-tester",
+			Version: version,
+			Rules:   sarifRules,
+		}},
+		Results: []sarifResult{},
+	}
+	for _, r := range reports {
+		for _, res := range r.Results {
+			if isFailure(res) {
+				run.Results = append(run.Results, sarifResultFor(r, res))
+			}
+		}
+	}
+
+	enc := json.NewEncoder(s.w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(sarifLog{
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Version: "2.1.0",
+		Runs:    []sarifRun{run},
+	})
+}
+
+// sarifResultFor describes a failed result. Its location is the URL of the
+// key, secret, or certificate tested, or of the vault for vault-wide tests,
+// and its fingerprint identifies the same finding across runs.
+func sarifResultFor(r *runReport, res testResult) sarifResult {
+	i := sarifRuleIndex(res)
+	rule := sarifRules[i]
+
+	text := fmt.Sprintf("%s failed%s: %s", resultLabel(res), failureTag(res), *res.Error)
+	if res.Remediation != "" {
+		text += "\n" + res.Remediation
+	}
+
+	uri, name, kind := sarifResource(r)
+	sum := sha256.Sum256([]byte(strings.Join([]string{rule.ID, uri, resultLabel(res)}, "\n")))
+	return sarifResult{
+		RuleID:    rule.ID,
+		RuleIndex: i,
+		Level:     rule.DefaultConfiguration.Level,
+		Message:   sarifMessage{Text: text},
+		Locations: []sarifLocation{{
+			PhysicalLocation: sarifPhysicalLocation{ArtifactLocation: sarifArtifactLocation{URI: uri}},
+			LogicalLocations: []sarifLogicalLocation{{Name: name, FullyQualifiedName: uri, Kind: kind}},
+		}},
+		PartialFingerprints: map[string]string{"azkvFinding/v1": hex.EncodeToString(sum[:16])},
+	}
+}
+
+// sarifResource returns the URL, name, and kind of what a report tested.
+func sarifResource(r *runReport) (uri, name, kind string) {
+	vault := strings.TrimSuffix(r.VaultURL, "/")
+	switch {
+	case r.KeyName != "":
+		return vault + "/keys/" + r.KeyName, r.KeyName, "key"
+	case r.SecretName != "":
+		return vault + "/secrets/" + r.SecretName, r.SecretName, "secret"
+	case r.CertificateName != "":
+		return vault + "/certificates/" + r.CertificateName, r.CertificateName, "certificate"
+	}
+	return vault, junitVaultHost(r.VaultURL), "vault"
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azsecrets"
+)
+
+// defaultProbeSecretName is the secret written by the set test. A dedicated
+// name keeps the test from overwriting a real secret.
+const defaultProbeSecretName = "azkeyvault-perm-tester-probe"
+
+// runSecretTests runs the selected secret-plane tests, passing each result
+// to record as it completes. It stops early and returns false as soon as
+// record returns false.
+func runSecretTests(ctx context.Context, client *azsecrets.Client, cfg testConfig, record func(testResult) bool) bool {
+	if cfg.secretGet {
+		res := testResult{Operation: opSecretGet}
+		var secret *secretInfo
+		err := cfg.timed(ctx, &res, func(ctx context.Context) (err error) {
+			secret, err = doTestGetSecret(ctx, client, cfg.secretName)
+			return err
+		})
+		if err != nil {
+			res.fail(err)
+		} else {
+			res.Success = true
+			res.SecretID = secret.id
+			length := len(secret.value)
+			res.SecretValueLength = &length
+			if cfg.showSecret {
+				res.SecretValue = secret.value
+			}
+		}
+		if !record(res) {
+			return false
+		}
+	}
+
+	if cfg.secretSet {
+		res := testResult{Operation: opSecretSet}
+		var id string
+		err := cfg.timed(ctx, &res, func(ctx context.Context) (err error) {
+			id, err = doTestSetSecret(ctx, client, cfg.secretSetName)
+			return err
+		})
+		if err != nil {
+			res.fail(err)
+		} else {
+			res.Success = true
+			res.SecretID = id
+		}
+		if !record(res) {
+			return false
+		}
+	}
+
+	return true
+}
+
+type secretInfo struct {
+	id    string
+	value string
+}
+
+func doTestGetSecret(ctx context.Context, client *azsecrets.Client, secretName string) (*secretInfo, error) {
+	resp, err := client.GetSecret(ctx, secretName, "", nil)
+	if err != nil {
+		return nil, fmt.Errorf("get secret operation failed: %w", err)
+	}
+	if resp.Value == nil {
+		return nil, fmt.Errorf("get secret operation returned no value")
+	}
+
+	info := &secretInfo{value: *resp.Value}
+	if resp.ID != nil {
+		info.id = string(*resp.ID)
+	}
+
+	return info, nil
+}
+
+func doTestSetSecret(ctx context.Context, client *azsecrets.Client, secretName string) (string, error) {
+	// The value is random so nothing meaningful is ever stored in the vault
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate secret value: %w", err)
+	}
+	value := hex.EncodeToString(buf)
+	contentType := "azkeyvault-perm-tester probe"
+
+	params := azsecrets.SetSecretParameters{
+		Value:       &value,
+		ContentType: &contentType,
+	}
+
+	resp, err := client.SetSecret(ctx, secretName, params, nil)
+	if err != nil {
+		return "", fmt.Errorf("set secret operation failed: %w", err)
+	}
+
+	if resp.ID == nil {
+		return "", nil
+	}
+	return string(*resp.ID), nil
+}
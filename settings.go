@@ -0,0 +1,122 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// envPrefix starts the name of the environment variable backing each flag,
+// e.g. AZKV_VAULT_URL for -vault-url.
+const envPrefix = "AZKV_"
+
+// envExcluded lists the flags that no environment variable sets: -version
+// and -list-algorithms print and exit, so they only make sense on the
+// command line, and a leftover AZKV_VERSION, e.g. from a deployment
+// recording the tool's version, must not stop every run.
+var envExcluded = map[string]bool{
+	"version":         true,
+	"list-algorithms": true,
+}
+
+// Sources of a setting, reported by loadSettings.
+const (
+	sourceFlag   = "flag"
+	sourceEnv    = "env"
+	sourceConfig = "config"
+)
+
+// settings is the resolved value of every flag, and where loadSettings
+// found each that is not at its default.
+type settings struct {
+	// values maps every flag name to its resolved value, as the flag
+	// formats it.
+	values map[string]string
+
+	// sources maps a flag name to sourceFlag, sourceEnv, or sourceConfig.
+	sources map[string]string
+}
+
+// envVarName returns the environment variable backing a flag.
+func envVarName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// loadSettings resolves the flags of fs that were not given on the command
+// line, with the precedence flag > environment > config file > default, and
+// returns the resolved settings. Each resolved value is also set on fs, so
+// that the variables the flags were defined with hold it. lookupEnv reads
+// the environment, as os.LookupEnv does. A variable that is set but empty is
+// ignored, as unset. The config file, configFile as set by -config or
+// AZKV_CONFIG, is applied last, to the flags neither source set. testFlags
+// are the flags that select tests.
+func loadSettings(fs *flag.FlagSet, lookupEnv func(string) (string, bool), configFile *string, testFlags map[string]*bool) (settings, error) {
+	s := settings{values: make(map[string]string), sources: make(map[string]string)}
+	err := s.resolve(fs, lookupEnv, *configFile, testFlags)
+	fs.VisitAll(func(f *flag.Flag) {
+		s.values[f.Name] = f.Value.String()
+	})
+	return s, err
+}
+
+// resolve sets the flags of fs from the environment and then configFile,
+// recording the source of each flag that is not at its default.
+func (s settings) resolve(fs *flag.FlagSet, lookupEnv func(string) (string, bool), configFile string, testFlags map[string]*bool) error {
+	fs.Visit(func(f *flag.Flag) {
+		s.sources[f.Name] = sourceFlag
+	})
+
+	var envErr error
+	fs.VisitAll(func(f *flag.Flag) {
+		if envErr != nil || s.sources[f.Name] != "" || envExcluded[f.Name] {
+			return
+		}
+		value, ok := lookupEnv(envVarName(f.Name))
+		if !ok || value == "" {
+			return
+		}
+		if err := fs.Set(f.Name, value); err != nil {
+			envErr = fmt.Errorf("invalid %s: %w", envVarName(f.Name), err)
+			return
+		}
+		s.sources[f.Name] = sourceEnv
+	})
+	if envErr != nil {
+		return envErr
+	}
+
+	if configFile == "" {
+		return nil
+	}
+	fileCfg, err := loadConfigFile(configFile)
+	if err != nil {
+		return err
+	}
+	// The profile only fills in what the command line and environment left
+	set := make(map[string]bool, len(s.sources))
+	for name := range s.sources {
+		set[name] = true
+	}
+	if err := fileCfg.apply(fs, set, testFlags); err != nil {
+		return err
+	}
+	fs.Visit(func(f *flag.Flag) {
+		if s.sources[f.Name] == "" {
+			s.sources[f.Name] = sourceConfig
+		}
+	})
+	return nil
+}
+
+// names returns the flags whose value came from source, sorted.
+func (s settings) names(source string) []string {
+	var names []string
+	for name, src := range s.sources {
+		if src == source {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
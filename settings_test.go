@@ -0,0 +1,154 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newSettingsFlags returns a flag set with a few of the command's flags, and
+// its test-selecting flags.
+func newSettingsFlags() (*flag.FlagSet, map[string]*bool) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.String("vault-url", "", "")
+	fs.String("algorithm", "RS256", "")
+	fs.Duration("timeout", 30*time.Second, "")
+	fs.Bool("version", false, "")
+	fs.Bool("list-algorithms", false, "")
+	testFlags := map[string]*bool{
+		"test-sign": fs.Bool("test-sign", true, ""),
+		"test-get":  fs.Bool("test-get", false, ""),
+	}
+	return fs, testFlags
+}
+
+func TestLoadSettings(t *testing.T) {
+	tests := []struct {
+		name   string
+		args   []string
+		env    map[string]string
+		config string
+
+		// want maps flag names to their resolved value and source, "" for
+		// a flag left at its default
+		want map[string][2]string
+	}{
+		{
+			name: "defaults",
+			want: map[string][2]string{
+				"vault-url": {"", ""},
+				"algorithm": {"RS256", ""},
+				"timeout":   {"30s", ""},
+				"test-sign": {"true", ""},
+			},
+		},
+		{
+			name:   "flag wins",
+			args:   []string{"-vault-url", "flagvault"},
+			env:    map[string]string{"AZKV_VAULT_URL": "envvault"},
+			config: "vaultUrl: configvault\n",
+			want:   map[string][2]string{"vault-url": {"flagvault", sourceFlag}},
+		},
+		{
+			name:   "environment over config file",
+			env:    map[string]string{"AZKV_VAULT_URL": "envvault", "AZKV_TIMEOUT": "5s"},
+			config: "vaultUrl: configvault\nalgorithm: ES256\n",
+			want: map[string][2]string{
+				"vault-url": {"envvault", sourceEnv},
+				"timeout":   {"5s", sourceEnv},
+				"algorithm": {"ES256", sourceConfig},
+			},
+		},
+		{
+			name:   "empty variable is unset",
+			env:    map[string]string{"AZKV_VAULT_URL": ""},
+			config: "vaultUrl: configvault\n",
+			want:   map[string][2]string{"vault-url": {"configvault", sourceConfig}},
+		},
+		{
+			name:   "config file tests",
+			env:    map[string]string{"AZKV_TEST_GET": "false"},
+			config: "tests: [get]\n",
+			want: map[string][2]string{
+				"test-get":  {"false", sourceEnv},
+				"test-sign": {"false", sourceConfig},
+			},
+		},
+		{
+			name: "action flags ignore the environment",
+			env:  map[string]string{"AZKV_VERSION": "1.2.3", "AZKV_LIST_ALGORITHMS": "true"},
+			want: map[string][2]string{
+				"version":         {"false", ""},
+				"list-algorithms": {"false", ""},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs, testFlags := newSettingsFlags()
+			if err := fs.Parse(tt.args); err != nil {
+				t.Fatal(err)
+			}
+			configFile := ""
+			if tt.config != "" {
+				configFile = filepath.Join(t.TempDir(), "profile.yaml")
+				if err := os.WriteFile(configFile, []byte(tt.config), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			lookupEnv := func(name string) (string, bool) {
+				value, ok := tt.env[name]
+				return value, ok
+			}
+
+			s, err := loadSettings(fs, lookupEnv, &configFile, testFlags)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for name, want := range tt.want {
+				if got := s.values[name]; got != want[0] {
+					t.Errorf("%s = %q, want %q", name, got, want[0])
+				}
+				if got := s.sources[name]; got != want[1] {
+					t.Errorf("%s source = %q, want %q", name, got, want[1])
+				}
+				if got := fs.Lookup(name).Value.String(); got != s.values[name] {
+					t.Errorf("flag %s = %q, but settings hold %q", name, got, s.values[name])
+				}
+			}
+		})
+	}
+}
+
+func TestLoadSettingsInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		env    map[string]string
+		config string
+	}{
+		{"environment", map[string]string{"AZKV_TIMEOUT": "soon"}, ""},
+		{"config file", nil, "timeout: soon\n"},
+		{"unknown config key", nil, "vaultURL: typo\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs, testFlags := newSettingsFlags()
+			configFile := ""
+			if tt.config != "" {
+				configFile = filepath.Join(t.TempDir(), "profile.yaml")
+				if err := os.WriteFile(configFile, []byte(tt.config), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			lookupEnv := func(name string) (string, bool) {
+				value, ok := tt.env[name]
+				return value, ok
+			}
+			if _, err := loadSettings(fs, lookupEnv, &configFile, testFlags); err == nil {
+				t.Error("loadSettings succeeded, want an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Encodings of the signature files written by -signature-out and read by
+// -verify-signature-in.
+const (
+	signatureEncodingRaw       = "raw"
+	signatureEncodingBase64URL = "base64url"
+)
+
+// parseSignatureEncoding validates a -signature-encoding value.
+func parseSignatureEncoding(name string) (string, error) {
+	switch enc := strings.ToLower(name); enc {
+	case signatureEncodingRaw, signatureEncodingBase64URL:
+		return enc, nil
+	}
+	return "", fmt.Errorf("unknown signature encoding %q (expected raw or base64url)", name)
+}
+
+// writeSignatureFile writes a detached signature to path in encoding.
+func writeSignatureFile(path string, signature []byte, encoding string) error {
+	data := signature
+	if encoding == signatureEncodingBase64URL {
+		data = []byte(base64.RawURLEncoding.EncodeToString(signature) + "\n")
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write signature file: %w", err)
+	}
+	return nil
+}
+
+// readSignatureFile reads a detached signature written in encoding. Base64url
+// signatures may be padded and may end with a newline.
+func readSignatureFile(path string, encoding string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signature file: %w", err)
+	}
+	if encoding == signatureEncodingBase64URL {
+		text := strings.TrimRight(string(bytes.TrimSpace(data)), "=")
+		if data, err = base64.RawURLEncoding.DecodeString(text); err != nil {
+			return nil, fmt.Errorf("signature file %s is not base64url: %w", path, err)
+		}
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("signature file %s is empty", path)
+	}
+	return data, nil
+}
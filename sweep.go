@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azkeys"
+
+	"azkeyvault-perm-tester/pkg/permtest"
+)
+
+// signatureAlgorithms lists every signature algorithm, in the order
+// -test-all-algorithms sweeps them.
+var signatureAlgorithms = append(permtest.SignatureAlgorithms(azkeys.KeyTypeRSA, ""),
+	azkeys.SignatureAlgorithmES256,
+	azkeys.SignatureAlgorithmES256K,
+	azkeys.SignatureAlgorithmES384,
+	azkeys.SignatureAlgorithmES512,
+	permtest.SignatureAlgorithmEdDSA,
+)
+
+// runAlgorithmSweep runs the signature tests once per signature algorithm
+// for -test-all-algorithms. Algorithms the key's type cannot use are recorded
+// as not applicable without being sent. With cfg.concurrency above 1, up to
+// that many algorithms run at once, and their results are recorded in sweep
+// order once all have completed, so the output does not depend on which
+// finished first. It returns false as soon as record does.
+func runAlgorithmSweep(ctx context.Context, client keyVaultClient, keyName string, key *keyInfo, cfg testConfig, record func(testResult) bool) bool {
+	algorithms, warning := algorithmsToTry(ctx, client, keyName, key, cfg)
+	sweep := algorithms
+	if warning == "" {
+		// The key type is known; show the algorithms it rules out too
+		sweep = signatureAlgorithms
+	}
+
+	first := true
+	tagged := func(alg azkeys.SignatureAlgorithm, res testResult) bool {
+		res.Algorithm = string(alg)
+		if first && warning != "" {
+			res.Warnings = append(res.Warnings, warning)
+			first = false
+		}
+		return record(res)
+	}
+	run := func(alg azkeys.SignatureAlgorithm, record func(testResult) bool) bool {
+		if !slices.Contains(algorithms, alg) {
+			for _, res := range notApplicableResults(cfg, alg, key) {
+				if !record(res) {
+					return false
+				}
+			}
+			return true
+		}
+		algCfg := cfg
+		algCfg.sigAlgorithm = alg
+		return tester{client: client, keyName: keyName, cfg: algCfg, key: key}.runSignatureTests(ctx, record)
+	}
+
+	if cfg.concurrency <= 1 {
+		for _, alg := range sweep {
+			if !run(alg, func(res testResult) bool { return tagged(alg, res) }) {
+				return false
+			}
+		}
+		return true
+	}
+
+	results := make([][]testResult, len(sweep))
+	sem := make(chan struct{}, cfg.concurrency)
+	var wg sync.WaitGroup
+	for i, alg := range sweep {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			run(alg, func(res testResult) bool {
+				results[i] = append(results[i], res)
+				return true
+			})
+		}()
+	}
+	wg.Wait()
+
+	for i, alg := range sweep {
+		for _, res := range results[i] {
+			if !tagged(alg, res) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// notApplicableResults returns a not-applicable result for each selected
+// signature test, for an algorithm that cannot be used with key.
+func notApplicableResults(cfg testConfig, alg azkeys.SignatureAlgorithm, key *keyInfo) []testResult {
+	var ops []string
+	if cfg.roundTrip {
+		ops = append(ops, opRoundTrip)
+	}
+	if cfg.sign && !cfg.roundTrip {
+		ops = append(ops, opSign)
+	}
+	if cfg.verify && !cfg.roundTrip {
+		ops = append(ops, opVerify)
+	}
+	if cfg.localVerify {
+		ops = append(ops, opLocalVerify)
+	}
+	if cfg.negativeVerify {
+		ops = append(ops, opNegativeVerify)
+	}
+
+	results := make([]testResult, len(ops))
+	for i, op := range ops {
+		results[i] = testResult{Operation: op}
+		results[i].notApplicable(fmt.Sprintf("Not applicable: %s cannot be used with this %s", alg, keyDescription(key.keyType, key.curve)))
+	}
+	return results
+}
+
+// algorithmMatrix aggregates the results of a -test-all-algorithms report
+// by algorithm and operation, e.g. {"PS256": {"sign": "passed"}}, with the
+// values of resultStatus.
+func algorithmMatrix(results []testResult) map[string]map[string]string {
+	matrix := make(map[string]map[string]string)
+	for _, res := range results {
+		if res.Algorithm == "" {
+			continue
+		}
+		if matrix[res.Algorithm] == nil {
+			matrix[res.Algorithm] = make(map[string]string)
+		}
+		matrix[res.Algorithm][res.Operation] = resultStatus(res)
+	}
+	if len(matrix) == 0 {
+		return nil
+	}
+	return matrix
+}
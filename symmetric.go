@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azkeys"
+
+	"azkeyvault-perm-tester/pkg/permtest"
+)
+
+// Sizes of the IV and authentication tag Managed HSM uses for AES-GCM, for
+// dry-run placeholders.
+const (
+	gcmIVSize  = 12
+	gcmTagSize = 16
+)
+
+// isAESAlgorithm reports whether alg encrypts with a symmetric AES key
+// rather than an RSA key.
+func isAESAlgorithm(alg azkeys.EncryptionAlgorithm) bool {
+	return permtest.IsGCM(alg) || permtest.IsCBC(alg)
+}
+
+// isOctKeyType reports whether keyType is a symmetric key.
+func isOctKeyType(keyType string) bool {
+	return keyType == string(azkeys.KeyTypeOct) || keyType == string(azkeys.KeyTypeOctHSM)
+}
+
+// encryptionPlaintext returns the message the encrypt test encrypts with
+// alg. Unpadded AES-CBC only takes whole blocks, so for it the test message
+// is padded with zeros to a multiple of the block size.
+func encryptionPlaintext(alg azkeys.EncryptionAlgorithm) []byte {
+	if !permtest.IsCBC(alg) || strings.HasSuffix(string(alg), "PAD") {
+		return testMessage
+	}
+	padding := (permtest.AESBlockSize - len(testMessage)%permtest.AESBlockSize) % permtest.AESBlockSize
+	return append(bytes.Clone(testMessage), make([]byte, padding)...)
+}
+
+// encryptionPlaceholder stands in for the output of an encryption that
+// -dry-run intercepted, so that the decrypt test can show its request. The
+// IV of AES-CBC is the one the encrypt request carried.
+func encryptionPlaceholder(err error, alg azkeys.EncryptionAlgorithm, iv []byte) *permtest.Ciphertext {
+	ciphertext := dryRunPlaceholder(err, 256)
+	if ciphertext == nil {
+		return nil
+	}
+	data := &permtest.Ciphertext{Value: ciphertext, IV: iv}
+	if permtest.IsGCM(alg) {
+		data.IV = make([]byte, gcmIVSize)
+		data.AuthenticationTag = make([]byte, gcmTagSize)
+	}
+	return data
+}
+
+// encryptionAlgorithmWarning explains why alg cannot be used with a key of
+// keyType, or returns "" when it can. Key Vault answers a mismatch with an
+// unhelpful 400, so the GET test warns before encrypt and decrypt fail.
+func encryptionAlgorithmWarning(alg azkeys.EncryptionAlgorithm, keyType string) string {
+	switch {
+	case keyType == "":
+		return ""
+	case isAESAlgorithm(alg) && !isOctKeyType(keyType):
+		return fmt.Sprintf("Encryption algorithm %s needs a symmetric (oct-HSM) key, but this is a %s key; encrypt and decrypt will fail", alg, keyType)
+	case !isAESAlgorithm(alg) && isOctKeyType(keyType):
+		return fmt.Sprintf("Encryption algorithm %s needs an RSA key, but this is a %s key; encrypt and decrypt will fail (use an AES algorithm such as A256GCM or A256CBCPAD)", alg, keyType)
+	}
+	return ""
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/template"
+)
+
+// templateReporter renders the whole run through a user-supplied Go
+// text/template once it completes, for -output template.
+type templateReporter struct {
+	w    io.Writer
+	tmpl *template.Template
+
+	// failuresOnly limits .Results to failures, for -quiet.
+	failuresOnly bool
+}
+
+// templateData is what a -template is executed with.
+type templateData struct {
+	Summary *runSummary
+	Reports []*runReport
+
+	// Results lists the results of every report, in order, each labelled
+	// with its vault and key, so that a template can range over them
+	// without nesting.
+	Results []templateResult
+}
+
+// templateResult is a result with the vault and summary row name of its
+// report. The fields of testResult are promoted, e.g. .Operation.
+type templateResult struct {
+	Vault string
+	Key   string
+	testResult
+}
+
+// loadTemplate returns the text of a -template value: the value itself, or
+// with a leading @ the contents of the file it names.
+func loadTemplate(value string) (string, error) {
+	path, ok := strings.CutPrefix(value, "@")
+	if !ok {
+		return value, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read template file: %w", err)
+	}
+	return string(data), nil
+}
+
+// newTemplateReporter parses text, which can use the functions of
+// templateFuncs. markers give the symbols of pass and fail.
+func newTemplateReporter(w io.Writer, text string, failuresOnly bool, markers textMarkers) (*templateReporter, error) {
+	tmpl, err := template.New("template").Funcs(templateFuncs(markers)).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -template: %w", err)
+	}
+	return &templateReporter{w: w, tmpl: tmpl, failuresOnly: failuresOnly}, nil
+}
+
+// templateFuncs returns the functions available to a -template:
+//
+//	upper, lower  change the case of a string
+//	join          joins a list of strings with a separator
+//	pass          the pass symbol for true and the fail symbol for false
+//	fail          the fail symbol for true and the pass symbol for false
+//	status        a result's status: passed, failed, mismatch, skipped, or notApplicable
+//	label         a result's summary label, e.g. "SIGN" or "SIGN PS384"
+func templateFuncs(markers textMarkers) template.FuncMap {
+	symbol := func(passed bool) string {
+		if passed {
+			return markers.cellPassed
+		}
+		return markers.cellFailed
+	}
+	return template.FuncMap{
+		"upper":  strings.ToUpper,
+		"lower":  strings.ToLower,
+		"join":   func(sep string, elems []string) string { return strings.Join(elems, sep) },
+		"pass":   symbol,
+		"fail":   func(failed bool) string { return symbol(!failed) },
+		"status": func(res templateResult) string { return resultStatus(res.testResult) },
+		"label":  func(res templateResult) string { return resultLabel(res.testResult) },
+	}
+}
+
+func (t *templateReporter) beginKey(r *runReport) {}
+
+func (t *templateReporter) result(res testResult) {}
+
+func (t *templateReporter) endKey(r *runReport) {}
+
+func (t *templateReporter) finish(reports []*runReport) error {
+	data := templateData{Summary: summarize(reports), Reports: reports}
+	if t.failuresOnly {
+		data.Reports = onlyFailures(reports)
+	}
+	for _, r := range data.Reports {
+		for _, res := range r.Results {
+			data.Results = append(data.Results, templateResult{Vault: r.VaultURL, Key: summaryRowName(r), testResult: res})
+		}
+	}
+	// Render fully before writing, so that a template failing midway does
+	// not leave half a report behind
+	var buf bytes.Buffer
+	if err := t.tmpl.Execute(&buf, data); err != nil {
+		return fmt.Errorf("failed to render -template: %w", err)
+	}
+	_, err := t.w.Write(buf.Bytes())
+	return err
+}
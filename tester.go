@@ -0,0 +1,639 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azkeys"
+
+	"azkeyvault-perm-tester/pkg/permtest"
+)
+
+// tester runs the tests of one key: it holds the client of the key's vault,
+// the key's name, and the settings of the run. Each test method performs one
+// operation and returns its result without recording it, so that a test can
+// be run and checked on its own; the runKeyTests and runSignatureTests
+// methods decide which tests run, in what order, and what they pass to each
+// other.
+type tester struct {
+	client  keyVaultClient
+	keyName string
+	cfg     testConfig
+
+	// key is what GET learned about the key, or nil if it has not run
+	key *keyInfo
+}
+
+// keyOps returns the permtest.Tester that sends the key operations of t,
+// with its key version and algorithms.
+func (t tester) keyOps() *permtest.Tester {
+	return permtest.New(t.client, permtest.Options{
+		KeyName:             t.keyName,
+		KeyVersion:          t.cfg.keyVersion,
+		SignatureAlgorithm:  t.cfg.sigAlgorithm,
+		EncryptionAlgorithm: t.cfg.encryptionAlgorithm,
+		WrapAlgorithm:       t.cfg.wrapAlgorithm,
+	})
+}
+
+// readKey reads the key and returns what it learned about it.
+func (t tester) readKey(ctx context.Context) (*keyInfo, error) {
+	res, key := t.keyOps().GetKey(ctx)
+	if res.Err != nil {
+		return nil, res.Err
+	}
+	return newKeyInfo(key), nil
+}
+
+// signatureSize returns the length of a signature made with the key and
+// t.cfg.sigAlgorithm. An RSA signature is as long as the key's modulus, which
+// is read from the key when GET has not run. A dry run cannot read it, so its
+// placeholders are sized for an RSA-2048 key.
+func (t tester) signatureSize(ctx context.Context) (int, error) {
+	if size := dummySignatureSize(t.cfg.sigAlgorithm); size > 0 {
+		return size, nil
+	}
+	key := t.key
+	if key == nil {
+		_, err := t.cfg.withRetry(ctx, func(ctx context.Context) (err error) {
+			key, err = t.readKey(ctx)
+			return err
+		})
+		if isDryRun(err) {
+			return 2048 / 8, nil
+		}
+		if err != nil {
+			return 0, fmt.Errorf("could not read the key's modulus: %s", classifyError(err).message)
+		}
+	}
+	if key.rsaBits == 0 {
+		return 0, fmt.Errorf("%s has no RSA modulus", keyDescription(key.keyType, key.curve))
+	}
+	return (key.rsaBits + 7) / 8, nil
+}
+
+// get reads the key. It returns the GET result and, when it succeeded, what
+// it learned about the key.
+func (t tester) get(ctx context.Context) (testResult, *keyInfo) {
+	res := testResult{Operation: opGet}
+	var info *keyInfo
+	err := t.cfg.timed(ctx, &res, func(ctx context.Context) (err error) {
+		info, err = t.readKey(ctx)
+		return err
+	})
+	if err != nil {
+		res.fail(err)
+		if !isDryRun(err) {
+			res.Notes = append(res.Notes, "The vault's soft-delete and purge protection settings are read from the key, so they are not reported without GET")
+		}
+		return res, nil
+	}
+
+	res.Success = true
+	res.KeyID = info.keyID
+	res.KeyVersion = info.version
+	res.KeyType = info.keyType
+	res.Curve = info.curve
+	if info.rsaBits > 0 {
+		res.KeySize = &info.rsaBits
+	}
+	res.KeyOps = info.keyOps
+	res.Enabled = info.enabled
+	res.NotBefore = info.notBefore
+	res.Expires = info.expires
+	res.Tags = info.tags
+	res.Managed = &info.managed
+	info.recovery.record(&res)
+	exportPublicKey(t.cfg, &res, info.jwk)
+	if problem := info.usabilityProblem(time.Now()); problem != "" {
+		res.Warnings = append(res.Warnings, fmt.Sprintf("The %s; Key Vault rejects cryptographic operations with it regardless of permissions", problem))
+	}
+	res.Warnings = append(res.Warnings, keyOpsWarnings(info.keyOps, t.cfg)...)
+	res.ProtectionLevel = info.protectionLevel(t.cfg.managedHSM)
+	hsmProtected := res.ProtectionLevel != protectionSoftware
+	res.HSMProtected = &hsmProtected
+	if t.cfg.encrypt || t.cfg.decrypt {
+		if w := encryptionAlgorithmWarning(t.cfg.encryptionAlgorithm, info.keyType); w != "" {
+			res.Warnings = append(res.Warnings, w)
+		}
+	}
+	if t.cfg.usesSignatureAlgorithm() && !t.cfg.allAlgorithms {
+		if w := algorithmWarning(t.cfg.sigAlgorithm, info.keyType, info.curve); w != "" {
+			res.Warnings = append(res.Warnings, w)
+		}
+	}
+	return res, info
+}
+
+// checkKeyPolicy checks the key read by GET, or nil if GET did not read it,
+// against -min-rsa-bits and -allowed-curves.
+func (t tester) checkKeyPolicy(key *keyInfo) testResult {
+	res := testResult{Operation: opKeyPolicy}
+	if key == nil {
+		res.skip("Key strength not checked: GET did not read the key")
+		return res
+	}
+
+	violation, note := t.cfg.keyPolicy.check(key)
+	if violation != "" {
+		res.checkFailed(categoryPolicy, violation)
+	} else {
+		res.Success = true
+	}
+	if note != "" {
+		res.Notes = append(res.Notes, note)
+	}
+	res.KeyType = key.keyType
+	res.Curve = key.curve
+	if key.rsaBits > 0 {
+		res.KeySize = &key.rsaBits
+	}
+	return res
+}
+
+// sign signs digest with cfg.sigAlgorithm. It returns the result and the
+// signature, a placeholder in a dry run, or nil if signing failed.
+func (t tester) sign(ctx context.Context, digest []byte, digestErr error) (testResult, []byte) {
+	res := testResult{Operation: opSign}
+	if w := t.cfg.payload.hashWarning(t.cfg.sigAlgorithm); w != "" {
+		res.Warnings = append(res.Warnings, w)
+	}
+
+	var signature []byte
+	err := digestErr
+	if err == nil {
+		err = t.cfg.timed(ctx, &res, func(ctx context.Context) error {
+			sign, sig := t.keyOps().Sign(ctx, digest)
+			signature, res.KeyVersion, res.SigningKeyID = sig, sign.KeyVersion, sign.KeyID
+			return sign.Err
+		})
+	}
+	if err != nil {
+		res.fail(err)
+		if !isDryRun(err) {
+			return res, nil
+		}
+		// A dry run always sizes the placeholder, so the error is nil
+		size, _ := t.signatureSize(ctx)
+		return res, dryRunPlaceholder(err, size)
+	}
+
+	res.Success = true
+	res.Signature = base64.StdEncoding.EncodeToString(signature)
+	saveSignature(t.cfg, &res, signature)
+	return res, signature
+}
+
+// verify asks Key Vault to verify signature over digest, or nil when there
+// is no signature to check. Without -test-sign it verifies a dummy
+// signature instead, which only proves the VERIFY permission.
+func (t tester) verify(ctx context.Context, digest []byte, digestErr error, signature []byte) testResult {
+	res := testResult{Operation: opVerify}
+	if t.cfg.verifySignature != nil {
+		res.Notes = append(res.Notes, fmt.Sprintf("Verifying the signature read from %s", t.cfg.verifySignatureIn))
+	}
+
+	// A standalone verify test has no real signature to check, so it
+	// verifies an all-zero one. Key Vault only evaluates a signature once
+	// the caller is authorized, so a clean "invalid" answer is proof of
+	// the VERIFY permission rather than a failure. Key Vault rejects a
+	// signature of the wrong length outright, so the dummy one is sized
+	// from the key.
+	dummy := signature == nil && !t.cfg.sign
+	var sizeErr error
+	if dummy {
+		var size int
+		if size, sizeErr = t.signatureSize(ctx); sizeErr == nil {
+			signature = make([]byte, size)
+		}
+	}
+
+	var valid bool
+	if sizeErr != nil {
+		res.skip(fmt.Sprintf("No signature to check, and a dummy one cannot be sized: %v. Use -test-sign or -verify-signature-in to check a real signature", sizeErr))
+	} else if signature == nil {
+		res.skip("No signature available from sign test, skipping verify test")
+	} else if digestErr != nil {
+		res.fail(digestErr)
+	} else if err := t.cfg.timed(ctx, &res, func(ctx context.Context) error {
+		var verify permtest.Result
+		verify, valid = t.keyOps().Verify(ctx, digest, signature)
+		return verify.Err
+	}); err != nil {
+		res.fail(err)
+	} else if dummy {
+		res.Success = true
+		res.Notes = append(res.Notes, "No signature to check, so a dummy signature was verified; Key Vault answered, so VERIFY is permitted and the signature was rejected as expected. Use -test-sign or -verify-signature-in to check a real signature")
+	} else if !valid {
+		res.mismatch("the signature did not verify against the payload")
+	} else {
+		res.Success = true
+	}
+	return res
+}
+
+// localVerify checks signature over digest with the key's public key, or
+// skips when there is no signature.
+func (t tester) localVerify(ctx context.Context, digest []byte, signature []byte) testResult {
+	res := testResult{Operation: opLocalVerify}
+	if signature == nil {
+		res.skip("No signature available from sign test, skipping local verification")
+	} else if err := t.cfg.timed(ctx, &res, func(ctx context.Context) error {
+		return doTestLocalVerify(ctx, t.client, t.keyName, t.cfg.keyVersion, digest, signature, t.cfg.sigAlgorithm)
+	}); err != nil {
+		// A curve Go cannot verify on says nothing about the signature
+		var curveErr *unsupportedCurveError
+		if errors.As(err, &curveErr) {
+			res.notApplicable(fmt.Sprintf("Not applicable: %v, so the signature cannot be verified locally; the VERIFY test checks it in Key Vault", curveErr))
+		} else {
+			res.fail(err)
+		}
+	} else {
+		res.Success = true
+	}
+	return res
+}
+
+// negativeVerify checks that Key Vault rejects signature for a tampered
+// copy of digest, or skips when there is no signature.
+func (t tester) negativeVerify(ctx context.Context, digest []byte, digestErr error, signature []byte) testResult {
+	res := testResult{Operation: opNegativeVerify}
+	var valid bool
+	if signature == nil {
+		res.skip("No signature available from sign test, skipping negative verify test")
+	} else if digestErr != nil {
+		res.fail(digestErr)
+	} else if err := t.cfg.timed(ctx, &res, func(ctx context.Context) error {
+		var verify permtest.Result
+		verify, valid = t.keyOps().Verify(ctx, tamperedDigest(digest), signature)
+		return verify.Err
+	}); err != nil {
+		res.fail(err)
+	} else if valid {
+		res.mismatch("Key Vault reported the signature as valid for a tampered digest, so VERIFY is not checking signatures")
+	} else {
+		res.Success = true
+		res.Notes = append(res.Notes, "Key Vault rejected the signature for a digest with one bit flipped, so VERIFY checks signatures cryptographically")
+	}
+	return res
+}
+
+// tamperedDigest returns a copy of digest with one bit flipped, which no
+// signature over digest may verify against.
+func tamperedDigest(digest []byte) []byte {
+	tampered := slices.Clone(digest)
+	tampered[0] ^= 0x01
+	return tampered
+}
+
+// encrypt encrypts the test message. It returns the result and the
+// encrypted data, a placeholder in a dry run, or nil if encryption failed.
+func (t tester) encrypt(ctx context.Context) (testResult, *permtest.Ciphertext) {
+	res := testResult{Operation: opEncrypt}
+	var data permtest.Ciphertext
+	err := t.cfg.timed(ctx, &res, func(ctx context.Context) error {
+		// On failure data still carries the IV that was sent, if any, for a
+		// dry-run placeholder
+		var encrypt permtest.Result
+		encrypt, data = t.keyOps().Encrypt(ctx, encryptionPlaintext(t.cfg.encryptionAlgorithm), t.cfg.aad)
+		res.KeyVersion = encrypt.KeyVersion
+		return encrypt.Err
+	})
+	if err != nil {
+		res.fail(err)
+		return res, encryptionPlaceholder(err, t.cfg.encryptionAlgorithm, data.IV)
+	}
+	res.Success = true
+	res.Ciphertext = base64.StdEncoding.EncodeToString(data.Value)
+	if data.IV != nil {
+		res.IV = base64.StdEncoding.EncodeToString(data.IV)
+	}
+	if data.AuthenticationTag != nil {
+		res.AuthenticationTag = base64.StdEncoding.EncodeToString(data.AuthenticationTag)
+	}
+	return res, &data
+}
+
+// decrypt decrypts ciphertext and checks that it yields the test message,
+// or skips when there is no ciphertext.
+func (t tester) decrypt(ctx context.Context, ciphertext *permtest.Ciphertext) testResult {
+	res := testResult{Operation: opDecrypt}
+	if ciphertext == nil {
+		res.skip("No ciphertext available from encrypt test, skipping decrypt test")
+		return res
+	}
+
+	var plaintext []byte
+	err := t.cfg.timed(ctx, &res, func(ctx context.Context) error {
+		var decrypt permtest.Result
+		decrypt, plaintext = t.keyOps().Decrypt(ctx, *ciphertext, t.cfg.aad)
+		res.KeyVersion = decrypt.KeyVersion
+		return decrypt.Err
+	})
+	if err != nil {
+		res.fail(err)
+	} else {
+		res.checkRoundTrip("decrypted plaintext", plaintext, encryptionPlaintext(t.cfg.encryptionAlgorithm))
+	}
+	return res
+}
+
+// wrap wraps symmetricKey. It returns the result and the wrapped key, a
+// placeholder in a dry run, or nil if wrapping failed.
+func (t tester) wrap(ctx context.Context, symmetricKey []byte) (testResult, []byte) {
+	res := testResult{Operation: opWrapKey}
+	var wrappedKey []byte
+	err := t.cfg.timed(ctx, &res, func(ctx context.Context) error {
+		var wrap permtest.Result
+		wrap, wrappedKey = t.keyOps().WrapKey(ctx, symmetricKey)
+		res.KeyVersion = wrap.KeyVersion
+		return wrap.Err
+	})
+	if err != nil {
+		res.fail(err)
+		return res, dryRunPlaceholder(err, 256)
+	}
+	res.Success = true
+	res.WrappedKey = base64.StdEncoding.EncodeToString(wrappedKey)
+	return res, wrappedKey
+}
+
+// unwrap unwraps wrappedKey and checks that it yields symmetricKey, or
+// skips when there is no wrapped key.
+func (t tester) unwrap(ctx context.Context, wrappedKey []byte, symmetricKey []byte) testResult {
+	res := testResult{Operation: opUnwrapKey}
+	if wrappedKey == nil {
+		res.skip("No wrapped key available from wrap test, skipping unwrap test")
+		return res
+	}
+
+	var unwrapped []byte
+	err := t.cfg.timed(ctx, &res, func(ctx context.Context) error {
+		var unwrap permtest.Result
+		unwrap, unwrapped = t.keyOps().UnwrapKey(ctx, wrappedKey)
+		res.KeyVersion = unwrap.KeyVersion
+		return unwrap.Err
+	})
+	if err != nil {
+		res.fail(err)
+		if !isDryRun(err) {
+			res.Notes = append(res.Notes, "WRAP succeeded but UNWRAP failed; these are separate Key Vault permissions")
+		}
+	} else {
+		res.checkRoundTrip("unwrapped key", unwrapped, symmetricKey)
+	}
+	return res
+}
+
+// getRotationPolicy reads the key's rotation policy. It returns the result
+// and the policy, or nil if it could not be read.
+func (t tester) getRotationPolicy(ctx context.Context) (testResult, *azkeys.KeyRotationPolicy) {
+	res := testResult{Operation: opRotationPolicyGet}
+	var policy azkeys.KeyRotationPolicy
+	err := t.cfg.timed(ctx, &res, func(ctx context.Context) (err error) {
+		policy, err = doTestGetRotationPolicy(ctx, t.client, t.keyName)
+		return err
+	})
+	if err != nil {
+		res.fail(err)
+		return res, nil
+	}
+	res.Success = true
+	res.RotationPolicy = describeRotationPolicy(policy)
+	return res, &policy
+}
+
+// checkRotationPolicy compares the rotation policy read by the get rotation
+// policy test, whose result is got, against -expect-rotation-within. A key
+// Key Vault reports no policy for fails as having none.
+func (t tester) checkRotationPolicy(got testResult, policy *azkeys.KeyRotationPolicy) testResult {
+	res := testResult{Operation: opRotationPolicyCheck}
+	if policy == nil && got.ErrorCategory == categoryNotFound {
+		res.RotationCompliance = rotationNoPolicy
+		res.checkFailed(categoryPolicy, "the key has no rotation policy, so it is never rotated automatically (-expect-rotation-within)")
+		return res
+	}
+	if policy == nil {
+		res.skip("Rotation policy not checked: the get rotation policy test did not read it")
+		return res
+	}
+
+	c := t.cfg.rotationExpectation.check(*policy)
+	if c.violation != "" {
+		res.checkFailed(categoryPolicy, c.violation)
+	} else {
+		res.Success = true
+	}
+	res.RotationCompliance = c.status
+	res.RotationTrigger = c.trigger
+	res.RotationExpiryTime = c.expiryTime
+	return res
+}
+
+// runKeyTests runs the selected tests against the key, passing each result
+// to record as it completes. Tests that passed within -skip-recent are
+// recorded as cached passes first and not run. It stops early and returns
+// false as soon as record returns false.
+func (t tester) runKeyTests(ctx context.Context, record func(testResult) bool) bool {
+	cfg, cached := skipCached(t.cfg)
+	t.cfg = cfg
+
+	// key is learned from GET, when it runs, for -test-all-algorithms
+	var key *keyInfo
+
+	// Every result carries the key's protection level, so that rows can be
+	// filtered by it. Only GET reveals the key type; until it has, the level
+	// is unknown except in a Managed HSM.
+	protection := protectionUnknown
+	if cfg.managedHSM {
+		protection = protectionManagedHSM
+	}
+	recordResult := record
+	record = func(res testResult) bool {
+		if res.ProtectionLevel == "" {
+			res.ProtectionLevel = protection
+		}
+		return recordResult(res)
+	}
+
+	for _, res := range cached {
+		if !record(res) {
+			return false
+		}
+	}
+
+	// GET runs first so that the key type it reports can flag an
+	// incompatible signature algorithm before sign and verify are attempted
+	if cfg.get {
+		var res testResult
+		res, key = t.get(ctx)
+		if key != nil {
+			protection = res.ProtectionLevel
+		}
+		t.key = key
+		if !record(res) {
+			return false
+		}
+	}
+
+	if cfg.keyPolicy.enabled() {
+		if !record(t.checkKeyPolicy(key)) {
+			return false
+		}
+	}
+
+	// A key Key Vault will refuse to use would fail every cryptographic test
+	// with an error that reads like a missing permission
+	if cfg.requireEnabled && key != nil {
+		if problem := key.usabilityProblem(time.Now()); problem != "" {
+			for _, op := range cfg.cryptoOperations() {
+				res := testResult{Operation: op}
+				res.checkFailed(categoryPrecondition, fmt.Sprintf("not attempted because the %s (-require-enabled)", problem))
+				if !record(res) {
+					return false
+				}
+			}
+			cfg.sign, cfg.verify, cfg.localVerify, cfg.negativeVerify, cfg.roundTrip = false, false, false, false, false
+			cfg.encrypt, cfg.decrypt, cfg.wrap, cfg.unwrap = false, false, false, false
+			t.cfg = cfg
+		}
+	}
+
+	if cfg.allAlgorithms && (cfg.sign || cfg.verify || cfg.localVerify) {
+		if !runAlgorithmSweep(ctx, t.client, t.keyName, key, cfg, record) {
+			return false
+		}
+	} else if !t.runSignatureTests(ctx, record) {
+		return false
+	}
+
+	var ciphertext *permtest.Ciphertext
+
+	if cfg.encrypt {
+		var res testResult
+		res, ciphertext = t.encrypt(ctx)
+		if !record(res) {
+			return false
+		}
+	}
+
+	if cfg.decrypt {
+		if !record(t.decrypt(ctx, ciphertext)) {
+			return false
+		}
+	}
+
+	var wrappedKey []byte
+	var symmetricKey []byte
+
+	if cfg.wrap || cfg.unwrap {
+		// Generate a throwaway 256-bit symmetric key to wrap. Without one
+		// neither wrap test can run, which fails them rather than the run,
+		// since other keys may be under test concurrently.
+		symmetricKey = make([]byte, 32)
+		if _, err := rand.Read(symmetricKey); err != nil {
+			err = fmt.Errorf("failed to generate the symmetric key to wrap: %w", err)
+			var ops []string
+			if cfg.wrap {
+				ops = append(ops, opWrapKey)
+			}
+			if cfg.unwrap {
+				ops = append(ops, opUnwrapKey)
+			}
+			for _, op := range ops {
+				res := testResult{Operation: op}
+				res.fail(err)
+				if !record(res) {
+					return false
+				}
+			}
+			cfg.wrap, cfg.unwrap = false, false
+		}
+	}
+
+	if cfg.wrap {
+		var res testResult
+		res, wrappedKey = t.wrap(ctx, symmetricKey)
+		if !record(res) {
+			return false
+		}
+	}
+
+	if cfg.unwrap {
+		if !record(t.unwrap(ctx, wrappedKey, symmetricKey)) {
+			return false
+		}
+	}
+
+	if cfg.getRotationPolicy {
+		res, policy := t.getRotationPolicy(ctx)
+		if !record(res) {
+			return false
+		}
+		if cfg.rotationExpectation.enabled() {
+			if !record(t.checkRotationPolicy(res, policy)) {
+				return false
+			}
+		}
+	}
+
+	return runBackupTests(ctx, t.client, t.keyName, cfg, record)
+}
+
+// runSignatureTests runs the selected sign, verify, and local verify tests
+// with t.cfg.sigAlgorithm, passing each result to record as it completes. It
+// stops early and returns false as soon as record returns false.
+func (t tester) runSignatureTests(ctx context.Context, record func(testResult) bool) bool {
+	cfg := t.cfg
+	digest, digestErr := cfg.payload.digestFor(cfg.sigAlgorithm)
+
+	var signature []byte
+	var signedSignature []byte
+
+	if cfg.roundTrip {
+		var res testResult
+		res, signedSignature = t.roundTrip(ctx, digest, digestErr)
+		if !record(res) {
+			return false
+		}
+	}
+
+	if cfg.sign && !cfg.roundTrip {
+		var res testResult
+		res, signature = t.sign(ctx, digest, digestErr)
+		signedSignature = signature
+		if !record(res) {
+			return false
+		}
+	}
+
+	if cfg.verifySignature != nil {
+		signature = cfg.verifySignature
+		signedSignature = cfg.verifySignature
+	}
+
+	if cfg.verify && !cfg.roundTrip {
+		if !record(t.verify(ctx, digest, digestErr, signature)) {
+			return false
+		}
+	}
+
+	if cfg.localVerify {
+		if !record(t.localVerify(ctx, digest, signedSignature)) {
+			return false
+		}
+	}
+
+	if cfg.negativeVerify {
+		if !record(t.negativeVerify(ctx, digest, digestErr, signedSignature)) {
+			return false
+		}
+	}
+
+	return true
+}
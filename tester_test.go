@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azkeys"
+)
+
+// newFakeTester returns a tester of every round trip on fake-key, sending
+// its requests to f.
+func newFakeTester(f *fakeClient) tester {
+	return tester{
+		client:  f,
+		keyName: "fake-key",
+		cfg: testConfig{
+			get:                 true,
+			sign:                true,
+			verify:              true,
+			encrypt:             true,
+			decrypt:             true,
+			wrap:                true,
+			unwrap:              true,
+			timeout:             time.Second,
+			sigAlgorithm:        azkeys.SignatureAlgorithmRS256,
+			encryptionAlgorithm: azkeys.EncryptionAlgorithmRSAOAEP256,
+			wrapAlgorithm:       azkeys.EncryptionAlgorithmRSAOAEP256,
+		},
+	}
+}
+
+// mustDigest is the digest tt signs and verifies.
+func mustDigest(tt tester) []byte {
+	digest, err := tt.cfg.payload.digestFor(tt.cfg.sigAlgorithm)
+	if err != nil {
+		panic(err)
+	}
+	return digest
+}
+
+// roundTrips runs every test of tt in the order runKeyTests does, feeding
+// each the output of the one before it.
+func roundTrips(ctx context.Context, tt tester) []testResult {
+	digest := mustDigest(tt)
+	get, _ := tt.get(ctx)
+	sign, signature := tt.sign(ctx, digest, nil)
+	verify := tt.verify(ctx, digest, nil, signature)
+	encrypt, ciphertext := tt.encrypt(ctx)
+	decrypt := tt.decrypt(ctx, ciphertext)
+	symmetricKey := make([]byte, 32)
+	wrap, wrapped := tt.wrap(ctx, symmetricKey)
+	unwrap := tt.unwrap(ctx, wrapped, symmetricKey)
+	return []testResult{get, sign, verify, encrypt, decrypt, wrap, unwrap}
+}
+
+func TestTesterPasses(t *testing.T) {
+	f := &fakeClient{}
+	for _, res := range roundTrips(context.Background(), newFakeTester(f)) {
+		if !res.Success || res.Skipped || res.Error != nil {
+			t.Errorf("%s: success = %v, skipped = %v, error = %v, want a pass", res.Operation, res.Success, res.Skipped, res.Error)
+		}
+	}
+}
+
+func TestTesterForbidden(t *testing.T) {
+	f := &fakeClient{errs: map[string]error{
+		opGet:       forbidden(),
+		opSign:      forbidden(),
+		opEncrypt:   forbidden(),
+		opWrapKey:   forbidden(),
+		opDecrypt:   forbidden(),
+		opUnwrapKey: forbidden(),
+	}}
+	results := roundTrips(context.Background(), newFakeTester(f))
+	for _, res := range results {
+		switch res.Operation {
+		case opVerify, opDecrypt, opUnwrapKey:
+			// Nothing to check without the output of sign, encrypt, or wrap
+			if !res.Skipped {
+				t.Errorf("%s: skipped = false, want true after its input failed", res.Operation)
+			}
+			continue
+		}
+		if res.Success {
+			t.Errorf("%s: success = true, want a failure", res.Operation)
+		}
+		if res.ErrorCategory != categoryForbidden || res.StatusCode != http.StatusForbidden {
+			t.Errorf("%s: category = %q, status = %d, want %q, %d", res.Operation, res.ErrorCategory, res.StatusCode, categoryForbidden, http.StatusForbidden)
+		}
+		if res.AuthorizationModel != authModelRBAC {
+			t.Errorf("%s: authorization model = %q, want %q", res.Operation, res.AuthorizationModel, authModelRBAC)
+		}
+		if res.Remediation == "" {
+			t.Errorf("%s: no remediation for a 403", res.Operation)
+		}
+		if res.RequestID != "fake-request-id" {
+			t.Errorf("%s: request ID = %q, want %q", res.Operation, res.RequestID, "fake-request-id")
+		}
+	}
+	for _, op := range []string{opVerify, opDecrypt, opUnwrapKey} {
+		if n := f.called(op); n != 0 {
+			t.Errorf("%s sent %d times, want 0 after its input failed", op, n)
+		}
+	}
+}
+
+func TestTesterUnwrapForbiddenAfterWrap(t *testing.T) {
+	f := &fakeClient{errs: map[string]error{opUnwrapKey: forbidden()}}
+	tt := newFakeTester(f)
+	ctx := context.Background()
+	symmetricKey := make([]byte, 32)
+	_, wrapped := tt.wrap(ctx, symmetricKey)
+	res := tt.unwrap(ctx, wrapped, symmetricKey)
+	if res.ErrorCategory != categoryForbidden {
+		t.Fatalf("category = %q, want %q", res.ErrorCategory, categoryForbidden)
+	}
+	if len(res.Notes) != 1 {
+		t.Errorf("notes = %q, want the note that WRAP and UNWRAP are separate permissions", res.Notes)
+	}
+}
+
+func TestTesterTimeout(t *testing.T) {
+	for _, op := range []string{opGet, opSign, opEncrypt, opWrapKey} {
+		t.Run(op, func(t *testing.T) {
+			f := &fakeClient{hang: map[string]bool{op: true}}
+			tt := newFakeTester(f)
+			tt.cfg.timeout = 20 * time.Millisecond
+			var res testResult
+			for _, r := range roundTrips(context.Background(), tt) {
+				if r.Operation == op {
+					res = r
+				}
+			}
+			if res.Success || res.Skipped {
+				t.Fatalf("success = %v, skipped = %v, want a failure", res.Success, res.Skipped)
+			}
+			if res.ErrorCategory != categoryTimeout {
+				t.Errorf("category = %q, want %q", res.ErrorCategory, categoryTimeout)
+			}
+			if res.StatusCode != 0 {
+				t.Errorf("status = %d, want 0 without a response", res.StatusCode)
+			}
+			if res.Duration < tt.cfg.timeout {
+				t.Errorf("duration = %v, want at least the %v timeout", res.Duration, tt.cfg.timeout)
+			}
+		})
+	}
+}
+
+func TestTesterInterrupted(t *testing.T) {
+	f := &fakeClient{hang: map[string]bool{opSign: true}}
+	tt := newFakeTester(f)
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	res, signature := tt.sign(ctx, mustDigest(tt), nil)
+	if !res.Skipped || res.ErrorCategory != "" {
+		t.Errorf("skipped = %v, category = %q, want an interrupted skip rather than a timeout", res.Skipped, res.ErrorCategory)
+	}
+	if signature != nil {
+		t.Errorf("signature = %x, want none", signature)
+	}
+}
+
+func TestTesterMismatch(t *testing.T) {
+	for _, op := range []string{opVerify, opDecrypt, opUnwrapKey} {
+		t.Run(op, func(t *testing.T) {
+			f := &fakeClient{wrong: map[string]bool{op: true}}
+			for _, res := range roundTrips(context.Background(), newFakeTester(f)) {
+				if res.Operation != op {
+					if !res.Success {
+						t.Errorf("%s: success = false, want a pass", res.Operation)
+					}
+					continue
+				}
+				if res.Success || !res.Mismatch {
+					t.Errorf("success = %v, mismatch = %v, want a mismatch", res.Success, res.Mismatch)
+				}
+				if res.ErrorCategory != categoryMismatch {
+					t.Errorf("category = %q, want %q", res.ErrorCategory, categoryMismatch)
+				}
+				if !isFailure(res) {
+					t.Error("a mismatch does not count as a failure")
+				}
+			}
+		})
+	}
+}
+
+func TestTesterLocalVerifyUnsupportedCurve(t *testing.T) {
+	kty, crv := azkeys.KeyTypeEC, azkeys.CurveNameP256K
+	f := &fakeClient{key: &azkeys.JSONWebKey{Kty: &kty, Crv: &crv, X: make([]byte, 32), Y: make([]byte, 32)}}
+	tt := newFakeTester(f)
+	tt.cfg.sigAlgorithm = azkeys.SignatureAlgorithmES256K
+	res := tt.localVerify(context.Background(), mustDigest(tt), make([]byte, 64))
+	if !res.NotApplicable || isFailure(res) {
+		t.Errorf("not applicable = %v, failure = %v, want not applicable", res.NotApplicable, isFailure(res))
+	}
+}
+
+func TestTesterDummyVerifySizedFromKey(t *testing.T) {
+	kty := azkeys.KeyTypeRSA
+	f := &fakeClient{key: &azkeys.JSONWebKey{Kty: &kty, N: append([]byte{0x80}, make([]byte, 511)...), E: []byte{1, 0, 1}}}
+	tt := newFakeTester(f)
+	tt.cfg.sign = false
+	res := tt.verify(context.Background(), mustDigest(tt), nil, nil)
+	if !res.Success {
+		t.Fatalf("success = false, want the dummy signature to pass: %v", res.Error)
+	}
+	if len(f.signatures) != 1 || len(f.signatures[0]) != 512 {
+		t.Errorf("verified %d signature(s), want one of 512 bytes for an RSA-4096 key", len(f.signatures))
+	} else if f.called(opGet) != 1 {
+		t.Errorf("GET sent %d times, want once to read the modulus", f.called(opGet))
+	}
+}
+
+func TestTesterDummyVerifyUnknownSize(t *testing.T) {
+	f := &fakeClient{errs: map[string]error{opGet: forbidden()}}
+	tt := newFakeTester(f)
+	tt.cfg.sign = false
+	res := tt.verify(context.Background(), mustDigest(tt), nil, nil)
+	if !res.Skipped {
+		t.Errorf("skipped = false, want a skip when the modulus cannot be read")
+	}
+	if n := f.called(opVerify); n != 0 {
+		t.Errorf("verify sent %d times, want 0 without a sized signature", n)
+	}
+}
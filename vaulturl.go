@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
+)
+
+// vaultTarget is a vault or Managed HSM endpoint that a run tests.
+type vaultTarget struct {
+	url        string
+	managedHSM bool
+}
+
+// cloudEnvironment describes the endpoints of one Azure cloud.
+type cloudEnvironment struct {
+	// name is the -cloud value that selects this cloud.
+	name        string
+	displayName string
+	vaultSuffix string
+
+	// hsmSuffix is the DNS suffix of Managed HSM endpoints. Managed HSM keys
+	// are always HSM-backed regardless of key type.
+	hsmSuffix string
+
+	config cloud.Configuration
+}
+
+// clouds lists the supported clouds; the first is the default.
+var clouds = []cloudEnvironment{
+	{
+		name:        "public",
+		displayName: "Azure Public",
+		vaultSuffix: ".vault.azure.net",
+		hsmSuffix:   ".managedhsm.azure.net",
+		config:      cloud.AzurePublic,
+	},
+	{
+		name:        "usgov",
+		displayName: "Azure Government",
+		vaultSuffix: ".vault.usgovcloudapi.net",
+		hsmSuffix:   ".managedhsm.usgovcloudapi.net",
+		config:      cloud.AzureGovernment,
+	},
+	{
+		name:        "china",
+		displayName: "Azure China",
+		vaultSuffix: ".vault.azure.cn",
+		hsmSuffix:   ".managedhsm.azure.cn",
+		config:      cloud.AzureChina,
+	},
+}
+
+// lookupCloud returns the cloud selected by a -cloud value.
+func lookupCloud(name string) (cloudEnvironment, error) {
+	var names []string
+	for _, c := range clouds {
+		if strings.EqualFold(c.name, name) {
+			return c, nil
+		}
+		names = append(names, c.name)
+	}
+	return cloudEnvironment{}, fmt.Errorf("unknown cloud %q (expected %s)", name, strings.Join(names, ", "))
+}
+
+// vaultNamePattern matches Key Vault and Managed HSM names: 3-24 letters,
+// digits, and hyphens, starting with a letter and not ending with a hyphen.
+var vaultNamePattern = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9-]{1,22}[a-zA-Z0-9]$`)
+
+// resolveVaultURL checks that raw names a Key Vault or Managed HSM endpoint
+// and returns it parsed. A bare vault name is expanded with the DNS suffix
+// of env, using the Managed HSM suffix when hsm is set. Errors suggest the
+// URL that was probably meant.
+func resolveVaultURL(raw string, env cloudEnvironment, hsm bool) (*url.URL, error) {
+	if !strings.Contains(raw, "://") {
+		if vaultNamePattern.MatchString(raw) {
+			suffix := env.vaultSuffix
+			if hsm {
+				suffix = env.hsmSuffix
+			}
+			return url.Parse("https://" + raw + suffix + "/")
+		}
+		if strings.Contains(raw, ".") {
+			return nil, fmt.Errorf("vault URL %q has no scheme; did you mean https://%s/?", raw, strings.TrimSuffix(raw, "/"))
+		}
+		return nil, fmt.Errorf("vault URL %q is neither a URL nor a valid vault name; pass the full endpoint, e.g. https://myvault%s/", raw, env.vaultSuffix)
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("vault URL %q is invalid: %w", raw, err)
+	}
+	host := u.Hostname()
+	if host == "" {
+		return nil, fmt.Errorf("vault URL %q has no host", raw)
+	}
+	if u.Scheme != "https" {
+		return nil, fmt.Errorf("vault URL %q must use https; did you mean https://%s/?", raw, u.Host)
+	}
+	if cloudForHost(host) == nil {
+		name, _, _ := strings.Cut(host, ".")
+		return nil, fmt.Errorf("vault URL %q is not a Key Vault or Managed HSM endpoint (expected a host ending in %s); did you mean https://%s%s/?", raw, strings.Join(knownSuffixes(), ", "), name, env.vaultSuffix)
+	}
+	if strings.Trim(u.Path, "/") != "" || u.RawQuery != "" {
+		return nil, fmt.Errorf("vault URL %q should be the vault endpoint only; did you mean https://%s/?", raw, u.Host)
+	}
+	return u, nil
+}
+
+// cloudForHost returns the cloud a vault or Managed HSM host belongs to, or
+// nil if it is not a recognized endpoint.
+func cloudForHost(host string) *cloudEnvironment {
+	host = strings.ToLower(host)
+	for i, c := range clouds {
+		if strings.HasSuffix(host, c.vaultSuffix) || strings.HasSuffix(host, c.hsmSuffix) {
+			return &clouds[i]
+		}
+	}
+	return nil
+}
+
+func knownSuffixes() []string {
+	var suffixes []string
+	for _, c := range clouds {
+		suffixes = append(suffixes, c.vaultSuffix, c.hsmSuffix)
+	}
+	return suffixes
+}
+
+// isManagedHSMHost reports whether host belongs to a Managed HSM endpoint.
+func isManagedHSMHost(host string) bool {
+	host = strings.ToLower(host)
+	for _, c := range clouds {
+		if strings.HasSuffix(host, c.hsmSuffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseKeyID splits a key identifier such as
+// https://myvault.vault.azure.net/keys/my-key/0123abcd into its vault URL,
+// key name, and version. The version is optional. Identifiers of secrets,
+// certificates, or anything else are rejected.
+func parseKeyID(raw string) (vaultURL, name, version string, err error) {
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return "", "", "", fmt.Errorf("key ID %q is not an https URL; expected https://<vault>%s/keys/<name>[/<version>]", raw, clouds[0].vaultSuffix)
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		return "", "", "", fmt.Errorf("key ID %q must not have a query or fragment", raw)
+	}
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] != "keys" || parts[1] == "" {
+		if len(parts) > 0 && (parts[0] == "secrets" || parts[0] == "certificates") {
+			return "", "", "", fmt.Errorf("%q identifies a %s, not a key", raw, strings.TrimSuffix(parts[0], "s"))
+		}
+		return "", "", "", fmt.Errorf("key ID %q does not name a key; expected https://%s/keys/<name>[/<version>]", raw, u.Host)
+	}
+	if len(parts) == 3 {
+		version = parts[2]
+	}
+	return "https://" + u.Host + "/", parts[1], version, nil
+}
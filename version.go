@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"runtime/debug"
+	"strings"
+)
+
+// Build information, set at link time with e.g.
+//
+//	go build -ldflags "-X main.version=v1.2.0 -X main.commit=$(git rev-parse HEAD) -X main.buildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// Values left empty are filled in from the build info Go embeds in the
+// binary, where available.
+var (
+	version   string
+	commit    string
+	buildDate string
+)
+
+// sdkModulePrefix selects the Azure SDK modules listed by -version.
+const sdkModulePrefix = "github.com/Azure/azure-sdk-for-go/"
+
+// printVersion writes the version, commit, and build date of the binary, and
+// the Azure SDK modules it was compiled against.
+func printVersion(w io.Writer) {
+	v, c, d := version, commit, buildDate
+	var deps []*debug.Module
+	if info, ok := debug.ReadBuildInfo(); ok {
+		if v == "" && info.Main.Version != "" {
+			v = info.Main.Version
+		}
+		var modified bool
+		for _, s := range info.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				if c == "" {
+					c = s.Value
+				}
+			case "vcs.time":
+				if d == "" {
+					d = s.Value
+				}
+			case "vcs.modified":
+				modified = s.Value == "true"
+			}
+		}
+		if modified && c != "" && commit == "" {
+			c += " (modified)"
+		}
+		deps = info.Deps
+	}
+
+	fmt.Fprintf(w, "azkeyvault-perm-tester %s\n", valueOr(v, "(devel)"))
+	fmt.Fprintf(w, "  commit:     %s\n", valueOr(c, "unknown"))
+	fmt.Fprintf(w, "  built:      %s\n", valueOr(d, "unknown"))
+	fmt.Fprintf(w, "  go:         %s %s/%s\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	for _, dep := range deps {
+		if !strings.HasPrefix(dep.Path, sdkModulePrefix) {
+			continue
+		}
+		if dep.Replace != nil {
+			dep = dep.Replace
+		}
+		fmt.Fprintf(w, "  %s %s\n", strings.TrimPrefix(dep.Path, sdkModulePrefix), dep.Version)
+	}
+}
+
+func valueOr(v, fallback string) string {
+	if v == "" {
+		return fallback
+	}
+	return v
+}
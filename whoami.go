@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+// tokenClaims are the claims of an access token that identify its
+// principal. Version 1 tokens carry appid and upn; version 2 tokens carry
+// azp and preferred_username instead.
+type tokenClaims struct {
+	ObjectID          string `json:"oid"`
+	AppID             string `json:"appid"`
+	AuthorizedParty   string `json:"azp"`
+	TenantID          string `json:"tid"`
+	UPN               string `json:"upn"`
+	PreferredUsername string `json:"preferred_username"`
+
+	// IdentityType is "app" for service principals and managed identities
+	// and "user" for users.
+	IdentityType string `json:"idtyp"`
+}
+
+// appID returns the application the token was issued to.
+func (c *tokenClaims) appID() string {
+	if c.AppID != "" {
+		return c.AppID
+	}
+	return c.AuthorizedParty
+}
+
+// username returns the signed-in user, or "" for an application.
+func (c *tokenClaims) username() string {
+	if c.UPN != "" {
+		return c.UPN
+	}
+	return c.PreferredUsername
+}
+
+// principalType returns the --assignee-principal-type of the identity the
+// token was issued to, or "" if the token does not say.
+func (c *tokenClaims) principalType() string {
+	switch c.IdentityType {
+	case "app":
+		return "ServicePrincipal"
+	case "user":
+		return "User"
+	}
+	return ""
+}
+
+// tokenScope returns the OAuth scope of the Key Vault or Managed HSM data
+// plane in env.
+func tokenScope(env cloudEnvironment, managedHSM bool) string {
+	suffix := env.vaultSuffix
+	if managedHSM {
+		suffix = env.hsmSuffix
+	}
+	return "https://" + strings.TrimPrefix(suffix, ".") + "/.default"
+}
+
+// whoami requests a token for scope and returns the claims identifying who
+// it was issued to.
+func whoami(ctx context.Context, cred azcore.TokenCredential, scope string) (*tokenClaims, error) {
+	token, err := cred.GetToken(ctx, policy.TokenRequestOptions{Scopes: []string{scope}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to acquire token: %w", err)
+	}
+	return decodeTokenClaims(token.Token)
+}
+
+// decodeTokenClaims decodes the payload of a JWT access token. The signature
+// is not verified; the claims are only displayed.
+func decodeTokenClaims(token string) (*tokenClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("access token is not a JWT")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode access token claims: %w", err)
+	}
+
+	claims := &tokenClaims{}
+	if err := json.Unmarshal(payload, claims); err != nil {
+		return nil, fmt.Errorf("failed to parse access token claims: %w", err)
+	}
+	return claims, nil
+}